@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/ssh"
 	"github.com/spf13/cobra"
 )
 
@@ -17,10 +18,48 @@ var (
 	identityFile string
 	timeout      string
 
+	// 多地址和重试
+	addHosts         []string
+	addHostSelection string
+	addRetries       int
+	addRetryBackoff  string
+	addAddressFamily string
+
+	// 连接池心跳和空闲超时
+	addKeepaliveInterval string
+	addKeepaliveCountMax int
+	addIdleTimeout       string
+	// 后台守护进程保留连接的时间
+	addControlPersist string
+
 	// 单行代理配置
 	proxy string
+	// https 代理的 CA 证书文件
+	addProxyCAFile string
+	// 代理命令
+	addProxyCommand string
+	// 跳板机连接别名
+	addJump []string
 
 	defaultCredential string
+	addCredentials    []string
+
+	// 主机密钥校验
+	strictHostKeyChecking string
+	useSystemKnownHosts   bool
+
+	// agent 转发和标签
+	addForwardAgent bool
+	addTags         []string
+
+	// 交互式输入密码的保留方式
+	addRememberSecret string
+
+	// 加密算法策略
+	addCiphers           []string
+	addKexAlgorithms     []string
+	addMACs              []string
+	addHostKeyAlgorithms []string
 )
 
 var addCmd = &cobra.Command{
@@ -56,8 +95,30 @@ var addCmd = &cobra.Command{
 				return fmt.Errorf("default credential '%s' not found", defaultCredential)
 			}
 		}
+		if addProxyCommand != "" && (len(addJump) > 0 || proxy != "") {
+			return fmt.Errorf("--proxy-command cannot be combined with --jump or --proxy")
+		}
+		if err := ssh.ValidateProxyCommand(addProxyCommand); err != nil {
+			return err
+		}
+		for _, hop := range addJump {
+			if _, exists := cfg.Connections[hop]; !exists {
+				return fmt.Errorf("jump host '%s' not found", hop)
+			}
+		}
+		for _, alias := range addCredentials {
+			if _, exists := cfg.Credentials[alias]; !exists {
+				return fmt.Errorf("credential '%s' not found", alias)
+			}
+		}
+		if err := ssh.ValidateHostKeyChecking(strictHostKeyChecking); err != nil {
+			return err
+		}
+		if err := config.ValidateRemember(addRememberSecret); err != nil {
+			return err
+		}
 		// 创建新连接配置
-		cfg.Connections[alias] = config.Connection{
+		conn := config.Connection{
 			Host:         host,
 			Port:         port,
 			User:         user,
@@ -65,11 +126,55 @@ var addCmd = &cobra.Command{
 			IdentityFile: identityFile,
 			Timeout:      timeout,
 
+			Hosts:         addHosts,
+			HostSelection: addHostSelection,
+			Retries:       addRetries,
+			RetryBackoff:  addRetryBackoff,
+			AddressFamily: addAddressFamily,
+
+			KeepaliveInterval: addKeepaliveInterval,
+			KeepaliveCountMax: addKeepaliveCountMax,
+			IdleTimeout:       addIdleTimeout,
+			ControlPersist:    addControlPersist,
+
 			// 使用新的单行代理配置
-			Proxy:             proxy,
+			Proxy:        proxy,
+			ProxyCAFile:  addProxyCAFile,
+			ProxyCommand: addProxyCommand,
+			Jump:         addJump,
 
 			DefaultCredential: defaultCredential,
+			Credentials:       addCredentials,
+
+			StrictHostKeyChecking: strictHostKeyChecking,
+			UseSystemKnownHosts:   useSystemKnownHosts,
+
+			ForwardAgent: addForwardAgent,
+			Tags:         addTags,
+
+			RememberSecret: addRememberSecret,
+
+			Ciphers:           addCiphers,
+			KexAlgorithms:     addKexAlgorithms,
+			MACs:              addMACs,
+			HostKeyAlgorithms: addHostKeyAlgorithms,
+		}
+		if err := ssh.ValidateAlgorithms(&conn); err != nil {
+			return err
+		}
+		if err := ssh.ValidateFailover(&conn); err != nil {
+			return err
+		}
+		if err := ssh.ValidateAddressFamily(conn.AddressFamily); err != nil {
+			return err
+		}
+		if err := ssh.ValidateKeepalive(&conn); err != nil {
+			return err
+		}
+		if err := ssh.ValidateControlPersist(conn.ControlPersist); err != nil {
+			return err
 		}
+		cfg.Connections[alias] = conn
 
 		// 保存配置
 		if err := config.SaveConfig(cfg); err != nil {
@@ -89,12 +194,54 @@ func init() {
 	addCmd.Flags().StringVarP(&password, "password", "P", "", "Password (not recommended, use identity file instead)")
 	addCmd.Flags().StringVarP(&identityFile, "identity-file", "i", "", "Identity file path")
 	addCmd.Flags().StringVarP(&timeout, "timeout", "t", "10s", "Connection timeout")
+	addCmd.Flags().StringSliceVar(&addHosts, "hosts", nil,
+		"Addresses to dial instead of --host, in order (host or host:port, comma separated or repeatable)")
+	addCmd.Flags().StringVar(&addHostSelection, "host-selection", "",
+		"How to pick among --hosts: ordered (default) or race")
+	addCmd.Flags().StringVar(&addAddressFamily, "address-family", "",
+		"Address family to use: inet (IPv4 only), inet6 (IPv6 only) or any (default)")
+	addCmd.Flags().IntVar(&addRetries, "retries", 0, "Number of retries when all addresses fail")
+	addCmd.Flags().StringVar(&addRetryBackoff, "retry-backoff", "",
+		"Delay before the first retry, doubled after each retry (default 1s)")
+	addCmd.Flags().StringVar(&addKeepaliveInterval, "keepalive-interval", "",
+		"Interval between keepalive requests on pooled connections, 0 to disable (default 30s)")
+	addCmd.Flags().IntVar(&addKeepaliveCountMax, "keepalive-count-max", 0,
+		"Unanswered keepalive requests before the connection is considered lost (default 3)")
+	addCmd.Flags().StringVar(&addIdleTimeout, "idle-timeout", "",
+		"Close pooled connections after being idle this long, 0 to keep them (default 30m)")
+	addCmd.Flags().StringVar(&addControlPersist, "control-persist", "",
+		"How long the sshm daemon keeps the connection after its last session: yes, no (bypass the daemon) or a duration")
 	// 添加默认凭证选项
 	addCmd.Flags().StringVar(&defaultCredential, "default-credential", "",
 		"Default credential to use for this connection")
+	addCmd.Flags().StringSliceVar(&addCredentials, "credentials", nil,
+		"Credentials to try in order after the default credential (comma separated or repeatable)")
 
 	// 添加单行代理配置选项
-	addCmd.Flags().StringVar(&proxy, "proxy", "", "Proxy configuration in URI format (http://[user:pass@]host:")
+	addCmd.Flags().StringVar(&proxy, "proxy", "", "Proxy configuration in URI format (http://[user:pass@]host:port, https://... or socks5://...)")
+	addCmd.Flags().StringVar(&addProxyCAFile, "proxy-ca-file", "", "Extra CA certificates (PEM) trusted when verifying an https:// proxy")
+	addCmd.Flags().StringVar(&addProxyCommand, "proxy-command", "",
+		"Command whose stdin/stdout is used as the transport (%h host, %p port, %r user, %% literal %)")
+	addCmd.Flags().StringSliceVarP(&addJump, "jump", "J", nil, "Jump host aliases to connect through, in order")
+
+	// 主机密钥校验选项
+	addCmd.Flags().StringVar(&strictHostKeyChecking, "strict-host-key-checking", "",
+		"Host key checking policy: yes, ask, accept-new or no (default: ask)")
+	addCmd.Flags().BoolVar(&useSystemKnownHosts, "use-system-known-hosts", false,
+		"Also verify host keys against ~/.ssh/known_hosts")
+
+	addCmd.Flags().BoolVarP(&addForwardAgent, "forward-agent", "A", false,
+		"Forward the local ssh-agent when connecting")
+	addCmd.Flags().StringSliceVar(&addTags, "tag", nil,
+		"Tags for grouping the connection (repeatable, 'untrusted' warns on agent forwarding)")
+	addCmd.Flags().StringVar(&addRememberSecret, "remember-secret", "",
+		"Keep prompted passwords: 'process' for this run, 'vault' to store them in the vault")
+
+	// 加密算法策略选项
+	addCmd.Flags().StringSliceVar(&addCiphers, "ciphers", nil, "Allowed ciphers in preference order")
+	addCmd.Flags().StringSliceVar(&addKexAlgorithms, "kex-algorithms", nil, "Allowed key exchange algorithms in preference order")
+	addCmd.Flags().StringSliceVar(&addMACs, "macs", nil, "Allowed MAC algorithms in preference order")
+	addCmd.Flags().StringSliceVar(&addHostKeyAlgorithms, "host-key-algorithms", nil, "Allowed host key algorithms in preference order")
 
 	addCmd.MarkFlagRequired("host")
 	addCmd.MarkFlagRequired("user")
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/justseemore/sshm/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+// algorithmsCmd 列出可以在算法策略中使用的取值
+var algorithmsCmd = &cobra.Command{
+	Use:   "algorithms",
+	Short: "List the algorithms usable in ciphers, kex_algorithms, macs and host_key_algorithms",
+	Long: `List the algorithms supported for each policy setting. Legacy algorithms are
+only used when a connection or the defaults section lists them explicitly.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for i, category := range ssh.AlgorithmCategories() {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s:\n", category.Name)
+			fmt.Printf("  default: %s\n", strings.Join(category.Supported, ", "))
+			fmt.Printf("  legacy:  %s\n", strings.Join(category.Insecure, ", "))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(algorithmsCmd)
+}
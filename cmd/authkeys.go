@@ -0,0 +1,486 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/sftp"
+	"github.com/justseemore/sshm/pkg/ssh"
+	"github.com/spf13/cobra"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+var (
+	// 要添加或删除的公钥
+	authkeysCredential  string
+	authkeysPublicKey   string
+	authkeysFingerprint string
+
+	// 轮换时生成的新密钥参数
+	rotateType       string
+	rotateBits       int
+	rotatePassphrase string
+	// 允许只轮换使用该凭证的部分连接
+	rotateForce bool
+)
+
+// authkeysCmd 管理一组连接上的 authorized_keys
+var authkeysCmd = &cobra.Command{
+	Use:   "authkeys",
+	Short: "Manage remote authorized_keys files across connections",
+	Long: `List, add, remove and rotate keys in ~/.ssh/authorized_keys over SFTP.
+Targets are connection aliases or tags; a tag selects every connection carrying it.`,
+}
+
+// authkeysListCmd 列出每台主机上的公钥
+var authkeysListCmd = &cobra.Command{
+	Use:   "list [alias|tag...]",
+	Short: "List the keys in authorized_keys on each connection",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, aliases, err := loadTargets(args)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CONNECTION\tLINE\tTYPE\tFINGERPRINT\tCOMMENT")
+		failed := 0
+		for _, alias := range aliases {
+			conn := cfg.Connections[alias]
+			cfg.ApplyDefaults(&conn)
+			keys, err := listAuthorizedKeys(&conn)
+			if err != nil {
+				fmt.Fprintf(w, "%s\t-\t-\terror: %v\t\n", alias, err)
+				failed++
+				continue
+			}
+			if len(keys) == 0 {
+				fmt.Fprintf(w, "%s\t-\t-\t(no keys)\t\n", alias)
+			}
+			for _, key := range keys {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", alias, key.LineNumber, key.Key.Type(),
+					gossh.FingerprintSHA256(key.Key), key.Comment)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("unable to read authorized_keys on %d of %d connection(s)", failed, len(aliases))
+		}
+		return nil
+	},
+}
+
+// authkeysAddCmd 将公钥添加到每台主机
+var authkeysAddCmd = &cobra.Command{
+	Use:   "add [alias|tag...]",
+	Short: "Add a public key to authorized_keys on each connection",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, aliases, err := loadTargets(args)
+		if err != nil {
+			return err
+		}
+
+		key, comment, err := selectedPublicKey(cfg)
+		if err != nil {
+			return err
+		}
+
+		return forEachTarget(cfg, aliases, func(conn *config.Connection) (string, error) {
+			client, err := sftp.NewSftpClient(conn, nil)
+			if err != nil {
+				return "", err
+			}
+			defer client.Close()
+
+			added, err := client.AddAuthorizedKey(key, comment)
+			if err != nil {
+				return "", err
+			}
+			if !added {
+				return "key already present", nil
+			}
+			return "key added", nil
+		})
+	},
+}
+
+// authkeysRemoveCmd 从每台主机删除公钥，删除后无法登录时回滚
+var authkeysRemoveCmd = &cobra.Command{
+	Use:   "remove [alias|tag...]",
+	Short: "Remove a public key from authorized_keys on each connection",
+	Long: `Remove a public key selected by --fingerprint or --credential. After removing,
+sshm logs in again with the connection's own authentication and restores the
+previous file if that fails. Key credentials are checked with their key alone,
+so a password or keyboard-interactive login does not count as verified.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, aliases, err := loadTargets(args)
+		if err != nil {
+			return err
+		}
+
+		fingerprint := authkeysFingerprint
+		if fingerprint == "" {
+			key, _, err := selectedPublicKey(cfg)
+			if err != nil {
+				return err
+			}
+			fingerprint = gossh.FingerprintSHA256(key)
+		} else if err := ssh.ValidateFingerprint(fingerprint); err != nil {
+			return err
+		}
+
+		return forEachTarget(cfg, aliases, func(conn *config.Connection) (string, error) {
+			client, err := sftp.NewSftpClient(conn, nil)
+			if err != nil {
+				return "", err
+			}
+			defer client.Close()
+
+			original, err := client.ReadAuthorizedKeys()
+			if err != nil {
+				return "", err
+			}
+
+			removed, err := client.RemoveAuthorizedKeys(func(key sftp.AuthorizedKey) bool {
+				return gossh.FingerprintSHA256(key.Key) == fingerprint
+			})
+			if err != nil {
+				return "", err
+			}
+			if removed == 0 {
+				return "key not present", nil
+			}
+
+			if err := verifyConnectionLogin(cfg, conn); err != nil {
+				return "", rollbackAuthorizedKeys(client, original, fmt.Errorf("login failed after removing the key: %w", err))
+			}
+			return fmt.Sprintf("removed %d entr%s, login verified", removed, pluralY(removed)), nil
+		})
+	},
+}
+
+// authkeysRotateCmd 为凭证生成新密钥并在每台主机上替换旧密钥
+var authkeysRotateCmd = &cobra.Command{
+	Use:   "rotate [credential] [alias|tag...]",
+	Short: "Replace a key credential's key on each connection with a new key",
+	Long: `Generate a new key pair for the credential, then on each connection: add the
+new public key, verify that logging in with the new key alone works and remove
+the old key. A connection where any step fails is restored to its previous
+authorized_keys.
+
+When every connection succeeds the credential is updated to use the new key.
+Otherwise the credential is left unchanged and the new key is registered as a
+separate credential so the rotated connections keep working.
+
+Connections that use the credential but are not targets would still only
+accept the old key, so rotation is refused unless they are included. With
+--force only the targets are rotated and the new key is registered as a
+separate credential, leaving the credential unchanged for the others.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		credAlias := args[0]
+
+		cfg, aliases, err := loadTargets(args[1:])
+		if err != nil {
+			return err
+		}
+
+		oldCred, exists := cfg.Credentials[credAlias]
+		if !exists {
+			return fmt.Errorf("credential with alias '%s' not found", credAlias)
+		}
+		if err := validateKeyFileName(credAlias); err != nil {
+			return err
+		}
+		oldKey, _, err := ssh.CredentialPublicKey(&oldCred)
+		if err != nil {
+			return err
+		}
+
+		// 其他使用该凭证的连接上只有旧公钥，凭证改用新密钥后将无法登录
+		var untouched []string
+		for _, alias := range credentialUsers(cfg, credAlias) {
+			if !slices.Contains(aliases, alias) {
+				untouched = append(untouched, alias)
+			}
+		}
+		if len(untouched) > 0 && !rotateForce {
+			return fmt.Errorf("credential '%s' is also used by %s, which would keep only the old key; "+
+				"include them as targets or use --force to register the new key as a separate credential",
+				credAlias, strings.Join(untouched, ", "))
+		}
+
+		// 生成新密钥，旧密钥文件保留不动
+		passphrase, err := readNewPassphrase(cmd, rotatePassphrase, "Passphrase for the new key (empty for no passphrase): ")
+		if err != nil {
+			return err
+		}
+		newKey, err := ssh.GenerateKey(rotateType, rotateBits, passphrase, credAlias)
+		if err != nil {
+			return err
+		}
+		keysDir := config.GetKeysDir()
+		if err := os.MkdirAll(keysDir, 0700); err != nil {
+			return fmt.Errorf("error creating keys directory: %w", err)
+		}
+		keyPath := filepath.Join(keysDir, fmt.Sprintf("%s-%s", credAlias, time.Now().Format("20060102150405")))
+		if err := writeNewFile(keyPath, newKey.PrivateKey); err != nil {
+			return err
+		}
+		if err := writeNewFile(keyPath+".pub", newKey.PublicKey); err != nil {
+			os.Remove(keyPath)
+			return err
+		}
+
+		newCred := oldCred
+		newCred.KeyPath = keyPath
+		newCred.KeyPassword = passphrase
+		newCred.KeyPasswordCommand = ""
+		newCred.CertPath = "" // 证书签发给旧密钥，新密钥需要重新签发
+
+		fmt.Printf("Rotating %s: %s -> %s\n", credAlias,
+			gossh.FingerprintSHA256(oldKey), gossh.FingerprintSHA256(newKey.Signer.PublicKey()))
+
+		var rotated []string
+		rotateErr := forEachTarget(cfg, aliases, func(conn *config.Connection) (string, error) {
+			if err := rotateAuthorizedKey(conn, &oldCred, &newCred, oldKey, newKey.Signer.PublicKey(), credAlias); err != nil {
+				return "", err
+			}
+			rotated = append(rotated, conn.Alias)
+			return "rotated, login verified", nil
+		})
+
+		if len(rotated) == 0 {
+			os.Remove(keyPath)
+			os.Remove(keyPath + ".pub")
+			return rotateErr
+		}
+
+		if rotateErr == nil && len(untouched) == 0 {
+			cfg.Credentials[credAlias] = newCred
+			if oldCred.CertPath != "" {
+				fmt.Printf("Warning: certificate %s was issued for the old key and has been removed from the credential\n", oldCred.CertPath)
+			}
+		} else {
+			// 部分主机失败或还有未轮换的连接：保留旧凭证，新密钥注册为独立凭证并由已轮换的连接使用
+			newAlias := filepath.Base(keyPath)
+			cfg.Credentials[newAlias] = newCred
+			for _, alias := range rotated {
+				conn := cfg.Connections[alias]
+				if conn.DefaultCredential == credAlias {
+					conn.DefaultCredential = newAlias
+				}
+				for i, name := range conn.Credentials {
+					if name == credAlias {
+						conn.Credentials[i] = newAlias
+					}
+				}
+				cfg.Connections[alias] = conn
+			}
+			fmt.Printf("Credential '%s' was kept for the connections that were not rotated; the new key is registered as '%s'\n", credAlias, newAlias)
+		}
+
+		if err := config.SaveConfig(cfg); err != nil {
+			return fmt.Errorf("error saving config: %w", err)
+		}
+		if rotateErr == nil && len(untouched) == 0 {
+			fmt.Printf("Credential '%s' now uses %s\n", credAlias, keyPath)
+		}
+		return rotateErr
+	},
+}
+
+// verifyConnectionLogin 确认连接仍能用自身的凭证登录，凭证链中任一凭证成功即可。
+// key 类型凭证只用其私钥验证，避免密码输入或 keyboard-interactive 掩盖密钥已失效
+func verifyConnectionLogin(cfg *config.Config, conn *config.Connection) error {
+	chain := conn.CredentialChain()
+	if len(chain) == 0 {
+		return ssh.VerifyLogin(conn, nil)
+	}
+
+	var failures []string
+	for _, alias := range chain {
+		cred, exists := cfg.Credentials[alias]
+		if !exists {
+			failures = append(failures, fmt.Sprintf("%s: credential not found", alias))
+			continue
+		}
+		var err error
+		if cred.Type == "key" {
+			err = ssh.VerifyKeyLogin(conn, &cred)
+		} else {
+			err = ssh.VerifyLogin(conn, &cred)
+		}
+		if err == nil {
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", alias, err))
+	}
+	return fmt.Errorf("no credential could log in: %s", strings.Join(failures, "; "))
+}
+
+// credentialUsers 返回使用凭证（默认凭证或凭证链）的连接别名，按名称排序
+func credentialUsers(cfg *config.Config, credAlias string) []string {
+	var users []string
+	for alias, conn := range cfg.Connections {
+		if conn.DefaultCredential == credAlias || slices.Contains(conn.Credentials, credAlias) {
+			users = append(users, alias)
+		}
+	}
+	slices.Sort(users)
+	return users
+}
+
+// rotateAuthorizedKey 在一台主机上添加新公钥、验证登录并删除旧公钥，任一步骤失败时恢复原文件
+func rotateAuthorizedKey(conn *config.Connection, oldCred, newCred *config.Credential, oldKey, newKey gossh.PublicKey, comment string) error {
+	client, err := sftp.NewSftpClient(conn, oldCred)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	original, err := client.ReadAuthorizedKeys()
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.AddAuthorizedKey(newKey, comment); err != nil {
+		return rollbackAuthorizedKeys(client, original, err)
+	}
+	if err := ssh.VerifyKeyLogin(conn, newCred); err != nil {
+		return rollbackAuthorizedKeys(client, original, fmt.Errorf("login with the new key failed: %w", err))
+	}
+
+	oldBlob := oldKey.Marshal()
+	if _, err := client.RemoveAuthorizedKeys(func(key sftp.AuthorizedKey) bool {
+		return bytes.Equal(key.Key.Marshal(), oldBlob)
+	}); err != nil {
+		return rollbackAuthorizedKeys(client, original, err)
+	}
+	return nil
+}
+
+// rollbackAuthorizedKeys 恢复原来的 authorized_keys 并返回导致回滚的错误
+func rollbackAuthorizedKeys(client *sftp.SftpClient, original []byte, cause error) error {
+	if err := client.WriteAuthorizedKeys(original); err != nil {
+		return fmt.Errorf("%w; rollback failed: %v", cause, err)
+	}
+	return fmt.Errorf("%w (rolled back)", cause)
+}
+
+// listAuthorizedKeys 使用连接自身的认证方式读取并解析 authorized_keys
+func listAuthorizedKeys(conn *config.Connection) ([]sftp.AuthorizedKey, error) {
+	client, err := sftp.NewSftpClient(conn, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	data, err := client.ReadAuthorizedKeys()
+	if err != nil {
+		return nil, err
+	}
+	return sftp.ParseAuthorizedKeys(data), nil
+}
+
+// loadTargets 加载配置并解析目标连接
+func loadTargets(names []string) (*config.Config, []string, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading config: %w", err)
+	}
+	aliases, err := cfg.ResolveConnections(names)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, aliases, nil
+}
+
+// selectedPublicKey 返回 --credential 或 --public-key 指定的公钥
+func selectedPublicKey(cfg *config.Config) (gossh.PublicKey, string, error) {
+	switch {
+	case authkeysCredential != "" && authkeysPublicKey != "":
+		return nil, "", fmt.Errorf("--credential and --public-key cannot be used together")
+	case authkeysCredential != "":
+		cred, exists := cfg.Credentials[authkeysCredential]
+		if !exists {
+			return nil, "", fmt.Errorf("credential with alias '%s' not found", authkeysCredential)
+		}
+		return ssh.CredentialPublicKey(&cred)
+	case authkeysPublicKey != "":
+		data, err := os.ReadFile(authkeysPublicKey)
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to read public key: %w", err)
+		}
+		key, comment, _, _, err := gossh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to parse public key %s: %w", authkeysPublicKey, err)
+		}
+		return key, comment, nil
+	default:
+		return nil, "", fmt.Errorf("specify the key with --credential or --public-key")
+	}
+}
+
+// forEachTarget 依次对每个连接执行操作并输出结果，返回失败汇总
+func forEachTarget(cfg *config.Config, aliases []string, fn func(conn *config.Connection) (string, error)) error {
+	var failed []string
+	for _, alias := range aliases {
+		conn := cfg.Connections[alias]
+		cfg.ApplyDefaults(&conn)
+		result, err := fn(&conn)
+		if err != nil {
+			fmt.Printf("  %s: FAILED: %v\n", alias, err)
+			failed = append(failed, alias)
+			continue
+		}
+		fmt.Printf("  %s: %s\n", alias, result)
+	}
+
+	if len(failed) > 0 {
+		slices.Sort(failed)
+		return fmt.Errorf("failed on %d of %d connection(s): %s", len(failed), len(aliases), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// pluralY 返回 entry/entries 的后缀
+func pluralY(n int) string {
+	if n == 1 {
+		return "y"
+	}
+	return "ies"
+}
+
+func init() {
+	rootCmd.AddCommand(authkeysCmd)
+	authkeysCmd.AddCommand(authkeysListCmd)
+	authkeysCmd.AddCommand(authkeysAddCmd)
+	authkeysCmd.AddCommand(authkeysRemoveCmd)
+	authkeysCmd.AddCommand(authkeysRotateCmd)
+
+	for _, c := range []*cobra.Command{authkeysAddCmd, authkeysRemoveCmd} {
+		c.Flags().StringVar(&authkeysCredential, "credential", "", "Use the public key of this key credential")
+		c.Flags().StringVar(&authkeysPublicKey, "public-key", "", "Use the public key in this file")
+	}
+	authkeysRemoveCmd.Flags().StringVar(&authkeysFingerprint, "fingerprint", "", "SHA256 fingerprint of the key to remove")
+
+	authkeysRotateCmd.Flags().StringVar(&rotateType, "type", ssh.KeyTypeEd25519, "Type of the new key: ed25519, rsa or ecdsa")
+	authkeysRotateCmd.Flags().IntVar(&rotateBits, "bits", 0, "Length of the new key (rsa: default 3072, ecdsa: 256, 384 or 521)")
+	authkeysRotateCmd.Flags().BoolVar(&rotateForce, "force", false,
+		"Rotate only the targets even if other connections use the credential")
+	authkeysRotateCmd.Flags().StringVar(&rotatePassphrase, "passphrase", "", "Encrypt the new private key with this passphrase instead of asking for it (visible in the process list)")
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"strconv"
 	"strings"
 
@@ -12,40 +13,51 @@ import (
 )
 
 var (
-	credentialAlias string // 连接时使用的凭证别名
-	connectPort     int    // 直接连接时的端口
-	connectUser     string // 直接连接时的用户名
+	credentialAlias string   // 连接时使用的凭证别名
+	connectPort     int      // 直接连接时的端口
+	connectUser     string   // 直接连接时的用户名
+	forwardAgent    bool     // 是否转发本地 ssh-agent
+	connectJump     []string // 跳板机连接别名，覆盖连接配置中的 jump
 )
 
 var connectCmd = &cobra.Command{
-	Use:     "connect [alias|host]",
+	Use:     "connect [alias|host] [-- command...]",
 	Aliases: []string{"login", "l"},
 	Short:   "Connect to a server using an alias or directly via IP/hostname",
-	Args:    cobra.ExactArgs(1),
+	Long: `Connect to a server using an alias or directly via IP/hostname.
+If a command is given after "--" it is executed on the server instead of
+opening a shell.`,
+	Example: `  sshm connect my-server --credential prod-key
+  sshm connect my-server -- df -h`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		target := args[0]
 
+		// 远程命令必须写在 -- 之后，避免与 sshm 自身的参数混淆
+		var command []string
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			if dash != 1 {
+				return fmt.Errorf("expected exactly one alias or host before '--', got %d", dash)
+			}
+			command = args[1:]
+		} else if len(args) > 1 {
+			return fmt.Errorf("unexpected argument '%s': put the remote command after '--'", args[1])
+		}
+
 		// 检查是配置别名还是直接IP/主机名
-		isDirectConnect := isIPorHostname(target)
+		isDirectConnect, err := isIPorHostname(target)
+		if err != nil {
+			return err
+		}
 
 		var conn *config.Connection
-		var err error
 
 		if isDirectConnect {
 			// 直接使用IP/主机名连接
-			port := connectPort
-			if port == 0 {
-				port = 22 // 默认SSH端口
-			}
-
-			// 创建临时连接配置
-			conn = &config.Connection{
-				Host: target,
-				Port: port,
-				User: connectUser,
+			conn, err = newDirectConnection(target)
+			if err != nil {
+				return err
 			}
-
-			// fmt.Println("Direct connection mode - using IP/hostname without saved configuration")
 		} else {
 			// 从配置加载连接别名
 			conn, err = config.GetConnection(target)
@@ -62,8 +74,8 @@ var connectCmd = &cobra.Command{
 			if err != nil {
 				return fmt.Errorf("error getting credential: %w", err)
 			}
-		} else if !isDirectConnect && conn.DefaultCredential != "" {
-			// 使用连接配置中的默认凭证（仅当使用别名时）
+		} else if !isDirectConnect && conn.DefaultCredential != "" && len(conn.Credentials) == 0 {
+			// 使用连接配置中的默认凭证（仅当使用别名时），配置了凭证链时由连接池依次尝试
 			cred, err = config.GetCredential(conn.DefaultCredential)
 			if err != nil {
 				return fmt.Errorf("error getting default credential: %w", err)
@@ -85,48 +97,98 @@ var connectCmd = &cobra.Command{
 			return fmt.Errorf("no username provided, please specify with --user or use a credential with username")
 		}
 
-		fmt.Printf("Connecting to %s (%s@%s:%d)...\n",
-			target, username, conn.Host, conn.Port)
+		if forwardAgent {
+			conn.ForwardAgent = true
+		}
+		if len(connectJump) > 0 {
+			conn.Jump = connectJump
+		}
+
+		// 指定了命令时直接执行，不打开交互式shell
+		if len(command) > 0 {
+			return ssh.ExecWithCredential(conn, cred, strings.Join(command, " "))
+		}
+
+		fmt.Printf("Connecting to %s (%s@%s)...\n",
+			target, username, net.JoinHostPort(conn.Host, strconv.Itoa(conn.Port)))
 
 		return ssh.ConnectWithCredential(conn, cred)
 	},
 }
 
-// isIPorHostname 检查给定的字符串是否像是IP地址或主机名
-func isIPorHostname(s string) bool {
-	// 检查是否是有效的IP地址
-	if net.ParseIP(s) != nil {
-		return true
+// isIPorHostname 检查给定的字符串是否应作为 IP 地址或主机名直接连接：
+// IP 地址（包括带 zone 的链路本地地址和 [v6]:port 形式）总是直接连接，其余字符串是已配置的别名时使用别名。
+// 无法读取配置时返回错误，而不是把别名当作主机名连接
+func isIPorHostname(s string) (bool, error) {
+	if host, _, err := splitDirectTarget(s); err == nil {
+		if _, err := netip.ParseAddr(host); err == nil {
+			return true, nil
+		}
+	}
+
+	// 已存在的配置别名优先于主机名
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return false, fmt.Errorf("error loading config: %w", err)
+	}
+	if _, exists := cfg.Connections[s]; exists {
+		return false, nil // 是已配置的别名
 	}
 
-	// 检查是否可能是主机名（包含点号但不是纯数字）
-	if strings.Contains(s, ".") {
-		// 尝试看是否可以解析为数字（如192.168.1.1）
-		parts := strings.Split(s, ".")
-		allNumbers := true
+	return true, nil // 当作IP/主机名处理
+}
 
-		for _, part := range parts {
-			if _, err := strconv.Atoi(part); err != nil {
-				allNumbers = false
-				break
-			}
-		}
+// splitDirectTarget 解析直接连接的目标，支持 host、host:port、IPv6 地址和 [IPv6]:port，
+// 未指定端口时返回 0
+func splitDirectTarget(target string) (string, int, error) {
+	// 不带方括号的 IPv6 地址（可能带 zone）本身包含冒号，不能再拆分端口
+	if _, err := netip.ParseAddr(target); err == nil {
+		return target, 0, nil
+	}
+	if strings.HasPrefix(target, "[") && strings.HasSuffix(target, "]") {
+		return target[1 : len(target)-1], 0, nil
+	}
+	if !strings.Contains(target, ":") {
+		return target, 0, nil
+	}
 
-		// 如果不全是数字，则可能是主机名
-		if !allNumbers {
-			return true
-		}
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid target '%s': %w", target, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in target '%s'", target)
 	}
+	return host, port, nil
+}
 
-	// 最后检查是否是已存在的配置别名
-	cfg, err := config.LoadConfig()
-	if err == nil {
-		if _, exists := cfg.Connections[s]; exists {
-			return false // 是已配置的别名
-		}
+// newDirectConnection 为直接连接的目标创建临时连接配置，目标中的端口与 --port 不能冲突
+func newDirectConnection(target string) (*config.Connection, error) {
+	host, port, err := splitDirectTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case port == 0 && connectPort != 0:
+		port = connectPort
+	case port == 0:
+		port = 22 // 默认SSH端口
+	case connectPort != 0 && connectPort != port:
+		return nil, fmt.Errorf("target '%s' conflicts with --port %d", target, connectPort)
 	}
 
-	return true // 当作IP/主机名处理
+	// 创建临时连接配置
+	conn := &config.Connection{
+		Host: host,
+		Port: port,
+		User: connectUser,
+	}
+	// 直接连接也使用全局默认值
+	if cfg, err := config.LoadConfig(); err == nil {
+		cfg.ApplyDefaults(conn)
+	}
+	return conn, nil
 }
 
 func init() {
@@ -136,5 +198,9 @@ func init() {
 		"Port to use when connecting directly to IP/hostname (default: 22)")
 	connectCmd.Flags().StringVarP(&connectUser, "user", "u", "",
 		"Username to use when connecting directly to IP/hostname")
+	connectCmd.Flags().BoolVarP(&forwardAgent, "forward-agent", "A", false,
+		"Forward the local ssh-agent to the server")
+	connectCmd.Flags().StringSliceVarP(&connectJump, "jump", "J", nil,
+		"Connect through these jump host aliases in order (comma separated)")
 	rootCmd.AddCommand(connectCmd)
 }
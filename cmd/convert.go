@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// 导出的私钥路径和密码
+	convertOutput     string
+	convertPassphrase string
+	// 导出后凭证改用新的私钥文件
+	convertUpdate bool
+)
+
+// credConvertCmd 将凭证的私钥（如 PuTTY .ppk）导出为 OpenSSH 格式
+var credConvertCmd = &cobra.Command{
+	Use:   "convert [alias]",
+	Short: "Export a credential's key (e.g. a PuTTY .ppk) in OpenSSH format",
+	Long: `Export the private key of a key credential in OpenSSH format, together with
+its public key. PuTTY .ppk (v2/v3), PEM and OpenSSH keys are accepted.
+
+The passphrase of the exported key is asked for in the terminal, or taken from
+--passphrase when there is no terminal. Leaving it empty in the terminal, or
+omitting --passphrase without a terminal, keeps the passphrase of the original
+key; pass --passphrase "" to export the key without a passphrase.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		alias := args[0]
+
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			return fmt.Errorf("error loading config: %w", err)
+		}
+
+		cred, exists := cfg.Credentials[alias]
+		if !exists {
+			return fmt.Errorf("credential with alias '%s' not found", alias)
+		}
+
+		output := convertOutput
+		if output == "" {
+			if err := os.MkdirAll(config.GetKeysDir(), 0700); err != nil {
+				return fmt.Errorf("error creating keys directory: %w", err)
+			}
+			output = filepath.Join(config.GetKeysDir(), alias)
+		}
+
+		// 未指定密码时沿用原私钥的密码
+		var passphrase *string
+		newPassphrase, err := readNewPassphrase(cmd, convertPassphrase,
+			"Passphrase for the exported key (empty to keep the original passphrase): ")
+		if err != nil {
+			return err
+		}
+		if newPassphrase != "" || cmd.Flags().Changed("passphrase") {
+			passphrase = &newPassphrase
+		}
+
+		key, err := ssh.ExportCredentialKey(&cred, passphrase, alias)
+		if err != nil {
+			return err
+		}
+
+		if err := writeNewFile(output, key.PrivateKey); err != nil {
+			return err
+		}
+		if err := writeNewFile(output+".pub", key.PublicKey); err != nil {
+			os.Remove(output)
+			return err
+		}
+		fmt.Printf("Private key written to %s\n", output)
+		fmt.Printf("Public key written to %s.pub\n", output)
+
+		if convertUpdate {
+			cred.KeyPath = output
+			if passphrase != nil {
+				cred.KeyPassword = *passphrase
+				cred.KeyPasswordCommand = ""
+			}
+			cfg.Credentials[alias] = cred
+			if err := config.SaveConfig(cfg); err != nil {
+				return fmt.Errorf("error saving config: %w", err)
+			}
+			fmt.Printf("Credential '%s' now uses %s\n", alias, output)
+		}
+		return nil
+	},
+}
+
+func init() {
+	credCmd.AddCommand(credConvertCmd)
+
+	credConvertCmd.Flags().StringVarP(&convertOutput, "output", "o", "",
+		"Path of the exported private key (default: ~/.config/sshm/keys/<alias>)")
+	credConvertCmd.Flags().StringVar(&convertPassphrase, "passphrase", "",
+		"Passphrase for the exported key instead of asking for it (empty for none, visible in the process list)")
+	credConvertCmd.Flags().BoolVar(&convertUpdate, "update", false,
+		"Point the credential at the exported key")
+}
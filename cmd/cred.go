@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/ssh"
 	"github.com/spf13/cobra"
 )
 
@@ -17,6 +20,21 @@ var (
 	credPassword  string
 	credKeyPath   string
 	credKeyPasswd string
+	credCertPath  string
+
+	// 从外部命令读取密码
+	credPasswordCmd    string
+	credKeyPasswordCmd string
+
+	// ssh-agent 密钥过滤
+	credAgentKey string
+
+	// 双因素认证
+	credTOTPSecret string
+	credChallenges []string
+
+	// 交互式输入密码的保留方式
+	credRememberSecret string
 )
 
 // credCmd 表示管理凭证的命令
@@ -44,8 +62,8 @@ var credAddCmd = &cobra.Command{
 		}
 
 		// 验证凭证类型
-		if credType != "key" && credType != "password" {
-			return fmt.Errorf("invalid credential type: must be 'key' or 'password'")
+		if credType != "key" && credType != "password" && credType != "agent" {
+			return fmt.Errorf("invalid credential type: must be 'key', 'password' or 'agent'")
 		}
 
 		// 验证必要参数
@@ -67,11 +85,40 @@ var credAddCmd = &cobra.Command{
 			if _, err := os.Stat(credKeyPath); os.IsNotExist(err) {
 				return fmt.Errorf("key file does not exist: %s", credKeyPath)
 			}
+
+			// 检查证书文件
+			if credCertPath != "" {
+				if credCertPath[0] == '~' {
+					homeDir, err := os.UserHomeDir()
+					if err != nil {
+						return fmt.Errorf("error getting home directory: %w", err)
+					}
+					credCertPath = filepath.Join(homeDir, credCertPath[1:])
+				}
+				if _, err := ssh.LoadCertificate(credCertPath); err != nil {
+					return err
+				}
+			}
 		} else if credType == "password" {
-			if credUsername == "" || credPassword == "" {
-				return fmt.Errorf("username and password are required for password type credential")
+			// 未提供密码时在连接时交互式输入
+			if credUsername == "" {
+				return fmt.Errorf("username is required for password type credential")
 			}
 		}
+		if err := config.ValidateRemember(credRememberSecret); err != nil {
+			return err
+		}
+
+		// 校验双因素认证参数
+		if credTOTPSecret != "" {
+			if err := ssh.ValidateTOTPSecret(credTOTPSecret); err != nil {
+				return err
+			}
+		}
+		challenges, err := parseChallenges(credChallenges)
+		if err != nil {
+			return err
+		}
 
 		// 创建新凭证
 		cfg.Credentials[alias] = config.Credential{
@@ -80,6 +127,17 @@ var credAddCmd = &cobra.Command{
 			Password:    credPassword,
 			KeyPath:     credKeyPath,
 			KeyPassword: credKeyPasswd,
+			CertPath:    credCertPath,
+
+			PasswordCommand:    credPasswordCmd,
+			KeyPasswordCommand: credKeyPasswordCmd,
+
+			AgentKey: credAgentKey,
+
+			TOTPSecret: credTOTPSecret,
+			Challenges: challenges,
+
+			RememberSecret: credRememberSecret,
 		}
 
 		// 保存配置
@@ -107,10 +165,21 @@ var credListCmd = &cobra.Command{
 			return nil
 		}
 
+		now := time.Now()
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-		fmt.Fprintln(w, "ALIAS\tTYPE\tUSERNAME\tKEY PATH")
+		fmt.Fprintln(w, "ALIAS\tTYPE\tUSERNAME\tKEY PATH\tCERTIFICATE")
 		for alias, cred := range cfg.Credentials {
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", alias, cred.Type, cred.Username, cred.KeyPath)
+			// 显示证书的主体、有效期和剩余时间
+			certInfo := "-"
+			if cred.CertPath != "" {
+				cert, err := ssh.LoadCertificate(cred.CertPath)
+				if err != nil {
+					certInfo = fmt.Sprintf("error: %v", err)
+				} else {
+					certInfo = ssh.DescribeCertificate(cert, now)
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", alias, cred.Type, cred.Username, cred.KeyPath, certInfo)
 		}
 		return w.Flush()
 	},
@@ -144,17 +213,51 @@ var credDeleteCmd = &cobra.Command{
 	},
 }
 
+// parseChallenges 解析 "正则=答案" 格式的 keyboard-interactive 应答规则。按第一个 "=" 分隔，
+// 答案（如 text:<固定答案>）可以包含 "="，正则中的 "=" 需写作 \x3d
+func parseChallenges(values []string) ([]config.Challenge, error) {
+	var challenges []config.Challenge
+	for _, value := range values {
+		i := strings.Index(value, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid challenge '%s': expected <prompt-regex>=<answer>", value)
+		}
+		challenge := config.Challenge{Prompt: value[:i], Answer: value[i+1:]}
+		if err := ssh.ValidateChallenge(challenge); err != nil {
+			return nil, err
+		}
+		challenges = append(challenges, challenge)
+	}
+	return challenges, nil
+}
+
 func init() {
 	rootCmd.AddCommand(credCmd)
 	credCmd.AddCommand(credAddCmd)
 	credCmd.AddCommand(credListCmd)
 	credCmd.AddCommand(credDeleteCmd)
 
-	credAddCmd.Flags().StringVar(&credType, "type", "", "Credential type: 'key' or 'password' (required)")
+	credAddCmd.Flags().StringVar(&credType, "type", "", "Credential type: 'key', 'password' or 'agent' (required)")
 	credAddCmd.Flags().StringVar(&credUsername, "username", "", "Username for the credential")
 	credAddCmd.Flags().StringVar(&credPassword, "password", "", "Password for the credential or for the key")
 	credAddCmd.Flags().StringVar(&credKeyPath, "key-path", "", "Path to the SSH key file")
 	credAddCmd.Flags().StringVar(&credKeyPasswd, "key-password", "", "Password for the SSH key file")
+	credAddCmd.Flags().StringVar(&credCertPath, "cert-path", "", "Path to the OpenSSH user certificate for the key")
+
+	credAddCmd.Flags().StringVar(&credPasswordCmd, "password-command", "",
+		"Command whose output is used as the password (e.g. 'pass show ssh/prod')")
+	credAddCmd.Flags().StringVar(&credKeyPasswordCmd, "key-password-command", "",
+		"Command whose output is used as the SSH key passphrase")
+
+	credAddCmd.Flags().StringVar(&credAgentKey, "agent-key", "",
+		"Only use the ssh-agent key with this comment or SHA256 fingerprint")
+
+	credAddCmd.Flags().StringVar(&credTOTPSecret, "totp-secret", "",
+		"Base32 TOTP secret used to answer one-time code prompts")
+	credAddCmd.Flags().StringArrayVar(&credChallenges, "challenge", nil,
+		"Keyboard-interactive rule <prompt-regex>=<totp|password|text:answer>; the answer may contain '=', write '=' in the regex as \\x3d (repeatable)")
+	credAddCmd.Flags().StringVar(&credRememberSecret, "remember-secret", "",
+		"Keep prompted passwords: 'process' for this run, 'vault' to store them in the vault")
 
 	credAddCmd.MarkFlagRequired("type")
 }
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/justseemore/sshm/pkg/config"
+)
+
+func TestParseChallenges(t *testing.T) {
+	tests := []struct {
+		value string
+		want  config.Challenge
+	}{
+		{"Verification code=totp", config.Challenge{Prompt: "Verification code", Answer: "totp"}},
+		{"Password:=password", config.Challenge{Prompt: "Password:", Answer: "password"}},
+		// 答案中的 "=" 属于答案
+		{"Token=text:abc=", config.Challenge{Prompt: "Token", Answer: "text:abc="}},
+		{"Secret=text:a=b==", config.Challenge{Prompt: "Secret", Answer: "text:a=b=="}},
+		// 正则中的 "=" 写作 \x3d
+		{`x\x3dy=text:1`, config.Challenge{Prompt: `x\x3dy`, Answer: "text:1"}},
+	}
+	for _, tt := range tests {
+		got, err := parseChallenges([]string{tt.value})
+		if err != nil {
+			t.Errorf("parseChallenges(%q): %v", tt.value, err)
+			continue
+		}
+		if len(got) != 1 || got[0] != tt.want {
+			t.Errorf("parseChallenges(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+
+	if !regexp.MustCompile(`x\x3dy`).MatchString("x=y") {
+		t.Error(`\x3d does not match "="`)
+	}
+
+	for _, value := range []string{"no separator", "=totp", "Code=unknown"} {
+		if _, err := parseChallenges([]string{value}); err == nil {
+			t.Errorf("parseChallenges(%q) succeeded, want an error", value)
+		}
+	}
+}
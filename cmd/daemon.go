@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// 在前台运行守护进程
+	daemonForeground bool
+	// 从标准输入读取启动进程解锁的保险库密钥，仅供后台启动时内部使用
+	daemonVaultKeyStdin bool
+	// 守护进程最多保持的连接数
+	daemonMaxConnections int
+)
+
+// 等待后台守护进程开始监听的时间
+const daemonStartTimeout = 5 * time.Second
+
+// daemonCmd 启动后台守护进程
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run a background daemon that keeps pooled connections alive",
+	Long: `Start a background daemon that keeps SSH connections open and shares them with
+later sshm invocations over a unix socket, so they skip the handshake and
+two-factor prompts. Prompts needed to open a new connection are shown in the
+terminal of the sshm command that asked for it.
+
+Connections with control_persist set to "no" never use the daemon.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if daemonForeground {
+			return runDaemon()
+		}
+		return startDaemon()
+	},
+}
+
+// daemonStatusCmd 显示守护进程状态
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the daemon is running",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		status, err := ssh.QueryDaemon()
+		if errors.Is(err, ssh.ErrDaemonNotRunning) {
+			fmt.Println("sshm daemon is not running.")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("sshm daemon is running (pid %d, up %s, %d pooled connections).\n",
+			status.PID, time.Since(status.Started).Round(time.Second), status.Connections)
+		fmt.Printf("Socket: %s\n", status.Socket)
+		return nil
+	},
+}
+
+// daemonStopCmd 停止守护进程
+var daemonStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Close all pooled connections and stop the daemon",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := ssh.StopDaemon()
+		if errors.Is(err, ssh.ErrDaemonNotRunning) {
+			fmt.Println("sshm daemon is not running.")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("sshm daemon stopped.")
+		return nil
+	},
+}
+
+// startDaemon 在后台启动守护进程，并等待其开始监听
+func startDaemon() error {
+	if status, err := ssh.QueryDaemon(); err == nil {
+		fmt.Printf("sshm daemon is already running (pid %d).\n", status.PID)
+		return nil
+	}
+
+	// 在前台解锁保险库，密钥通过管道传给守护进程
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to locate sshm executable: %w", err)
+	}
+	args := []string{"daemon", "--foreground", "--max-connections", strconv.Itoa(daemonMaxConnections)}
+	if ssh.Verbose {
+		args = append(args, "--verbose")
+	}
+	vaultKey := config.UnlockedVaultKey()
+	if cfg.Vault != nil && vaultKey != nil {
+		args = append(args, "--vault-key-stdin")
+	}
+
+	logPath := config.GetDaemonLogPath()
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open daemon log: %w", err)
+	}
+	defer logFile.Close()
+
+	child := exec.Command(exe, args...)
+	child.Stdout = logFile
+	child.Stderr = logFile
+	// 脱离当前终端的会话，关闭终端后继续运行
+	child.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	var stdin io.WriteCloser
+	if vaultKey != nil && cfg.Vault != nil {
+		if stdin, err = child.StdinPipe(); err != nil {
+			return err
+		}
+	}
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("unable to start sshm daemon: %w", err)
+	}
+	if stdin != nil {
+		fmt.Fprintln(stdin, hex.EncodeToString(vaultKey))
+		stdin.Close()
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- child.Wait()
+	}()
+	deadline := time.After(daemonStartTimeout)
+	for {
+		if status, err := ssh.QueryDaemon(); err == nil {
+			fmt.Printf("sshm daemon started (pid %d).\n", status.PID)
+			return nil
+		}
+		select {
+		case err := <-exited:
+			return fmt.Errorf("sshm daemon exited (%v), see %s", err, logPath)
+		case <-deadline:
+			return fmt.Errorf("sshm daemon did not start within %s, see %s", daemonStartTimeout, logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// runDaemon 在前台运行守护进程，直到收到停止请求或 SIGINT/SIGTERM
+func runDaemon() error {
+	if daemonVaultKeyStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("unable to read vault key: %w", err)
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(line))
+		if err != nil {
+			return fmt.Errorf("invalid vault key: %w", err)
+		}
+		if err := config.UseVaultKey(key); err != nil {
+			return err
+		}
+	}
+	// 提前解锁保险库，之后处理连接请求时不再需要主密码
+	if _, err := config.LoadConfig(); err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+
+	daemon, err := ssh.ListenDaemon()
+	if err != nil {
+		return err
+	}
+	ssh.GetConnectionPool().SetMaxConnections(daemonMaxConnections)
+	log.Printf("sshm daemon listening on %s (pid %d)", config.GetDaemonSocketPath(), os.Getpid())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Printf("received %s, shutting down", sig)
+			daemon.Close()
+		case <-daemon.Done():
+		}
+	}()
+
+	err = daemon.Serve()
+	log.Printf("sshm daemon stopped")
+	return err
+}
+
+func init() {
+	daemonCmd.Flags().BoolVar(&daemonForeground, "foreground", false,
+		"Run the daemon in the foreground instead of starting it in the background")
+	daemonCmd.Flags().BoolVar(&daemonVaultKeyStdin, "vault-key-stdin", false,
+		"Read the unlocked vault key from standard input")
+	_ = daemonCmd.Flags().MarkHidden("vault-key-stdin")
+	daemonCmd.Flags().IntVar(&daemonMaxConnections, "max-connections", ssh.DefaultMaxConnections,
+		"Maximum number of pooled connections, least recently used idle connections are closed first (0 for no limit)")
+
+	rootCmd.AddCommand(daemonCmd)
+	daemonCmd.AddCommand(daemonStatusCmd)
+	daemonCmd.AddCommand(daemonStopCmd)
+}
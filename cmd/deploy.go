@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/sftp"
+	"github.com/justseemore/sshm/pkg/ssh"
+	"github.com/spf13/cobra"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+var (
+	// 部署成功后将凭证设为连接的默认凭证
+	deploySetDefault bool
+)
+
+// credDeployCmd 将凭证的公钥安装到一个或多个连接的 authorized_keys
+var credDeployCmd = &cobra.Command{
+	Use:   "deploy [credential] [alias|tag...]",
+	Short: "Install a key credential's public key on one or more connections",
+	Long: `Log in with each connection's current authentication, append the credential's
+public key to ~/.ssh/authorized_keys over SFTP (skipping keys that are already
+present) and verify that logging in with the credential's key alone works.
+
+Targets are connection aliases or tags; a tag selects every connection carrying it.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		credAlias := args[0]
+
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			return fmt.Errorf("error loading config: %w", err)
+		}
+
+		cred, exists := cfg.Credentials[credAlias]
+		if !exists {
+			return fmt.Errorf("credential with alias '%s' not found", credAlias)
+		}
+
+		aliases, err := cfg.ResolveConnections(args[1:])
+		if err != nil {
+			return err
+		}
+
+		pubKey, comment, err := ssh.CredentialPublicKey(&cred)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deploying %s (%s) to %d connection(s)\n", gossh.FingerprintSHA256(pubKey), credAlias, len(aliases))
+
+		failed := 0
+		for _, alias := range aliases {
+			conn := cfg.Connections[alias]
+			cfg.ApplyDefaults(&conn)
+			if err := deployKey(&conn, &cred, pubKey, comment); err != nil {
+				fmt.Printf("  %s: FAILED: %v\n", alias, err)
+				failed++
+				continue
+			}
+
+			if deploySetDefault {
+				stored := cfg.Connections[alias]
+				stored.DefaultCredential = credAlias
+				cfg.Connections[alias] = stored
+			}
+		}
+
+		if deploySetDefault && failed < len(aliases) {
+			if err := config.SaveConfig(cfg); err != nil {
+				return fmt.Errorf("error saving config: %w", err)
+			}
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("deploy failed on %d of %d connection(s)", failed, len(aliases))
+		}
+		return nil
+	},
+}
+
+// deployKey 使用连接当前的认证方式安装公钥，然后只用新凭证的私钥验证登录
+func deployKey(conn *config.Connection, cred *config.Credential, pubKey gossh.PublicKey, comment string) error {
+	client, err := sftp.NewSftpClient(conn, nil)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	added, err := client.AddAuthorizedKey(pubKey, comment)
+	if err != nil {
+		return err
+	}
+
+	if err := ssh.VerifyKeyLogin(conn, cred); err != nil {
+		return fmt.Errorf("key installed but login with the credential failed: %w", err)
+	}
+
+	if added {
+		fmt.Printf("  %s: key added, login verified\n", conn.Alias)
+	} else {
+		fmt.Printf("  %s: key already present, login verified\n", conn.Alias)
+	}
+	return nil
+}
+
+func init() {
+	credCmd.AddCommand(credDeployCmd)
+
+	credDeployCmd.Flags().BoolVar(&deploySetDefault, "set-default", false,
+		"Make the credential the default credential of every connection it was deployed to")
+}
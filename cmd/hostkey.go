@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+	"github.com/justseemore/sshm/pkg/ssh"
+	"github.com/spf13/cobra"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+var (
+	// 主机密钥固定相关参数
+	hostKeyPinFingerprint string
+	hostKeyPinType        string
+	hostKeyPinYes         bool
+)
+
+// hostKeyCmd 管理连接的主机密钥
+var hostKeyCmd = &cobra.Command{
+	Use:   "hostkey",
+	Short: "Manage host keys of SSH connections",
+	Long:  `Scan, pin, show and forget the host keys used to verify SSH servers.`,
+}
+
+// hostKeyScanCmd 扫描服务器的主机密钥
+var hostKeyScanCmd = &cobra.Command{
+	Use:   "scan [alias]",
+	Short: "Scan the host key presented by a server",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		alias := args[0]
+
+		conn, err := config.GetConnection(alias)
+		if err != nil {
+			return fmt.Errorf("error getting connection: %w", err)
+		}
+
+		key, err := ssh.ScanHostKey(conn)
+		if err != nil {
+			return err
+		}
+
+		fingerprint := gossh.FingerprintSHA256(key)
+		fmt.Printf("%s (%s) %s %s\n", alias, net.JoinHostPort(conn.Host, strconv.Itoa(conn.Port)), key.Type(), fingerprint)
+
+		if conn.HostKeyFingerprint != "" {
+			if conn.HostKeyFingerprint == fingerprint {
+				fmt.Println("Matches the pinned host key.")
+			} else {
+				fmt.Printf("Does NOT match the pinned host key %s %s.\n", conn.HostKeyType, conn.HostKeyFingerprint)
+			}
+		}
+		return nil
+	},
+}
+
+// hostKeyPinCmd 固定连接的主机密钥
+var hostKeyPinCmd = &cobra.Command{
+	Use:   "pin [alias]",
+	Short: "Pin the host key fingerprint of a connection",
+	Long: `Pin the host key fingerprint of a connection. Without --fingerprint the
+server is scanned and its current host key is pinned. Replacing an existing
+pin shows the old and new fingerprints and requires confirmation.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		alias := args[0]
+
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			return fmt.Errorf("error loading config: %w", err)
+		}
+
+		conn, exists := cfg.Connections[alias]
+		if !exists {
+			return fmt.Errorf("connection with alias '%s' not found", alias)
+		}
+
+		// 确定新的密钥类型和指纹
+		keyType, fingerprint := hostKeyPinType, hostKeyPinFingerprint
+		if fingerprint != "" {
+			if err := ssh.ValidateFingerprint(fingerprint); err != nil {
+				return err
+			}
+		} else {
+			// 与 connect 一样应用全局默认值（代理、算法等），配置中只写回未修改的连接；
+			// 扫描时不限制密钥类型，以便发现服务器更换的密钥类型
+			scanConn := conn
+			cfg.ApplyDefaults(&scanConn)
+			scanConn.HostKeyType = ""
+			key, err := ssh.ScanHostKey(&scanConn)
+			if err != nil {
+				return err
+			}
+			keyType, fingerprint = key.Type(), gossh.FingerprintSHA256(key)
+		}
+
+		if conn.HostKeyFingerprint == fingerprint && conn.HostKeyType == keyType {
+			fmt.Printf("Host key of '%s' is already pinned to %s %s.\n", alias, keyType, fingerprint)
+			return nil
+		}
+
+		// 轮换已固定的密钥需要明确确认
+		if conn.HostKeyFingerprint != "" {
+			fmt.Printf("Host key of '%s' is changing:\n", alias)
+			fmt.Printf("  old: %s %s\n", conn.HostKeyType, conn.HostKeyFingerprint)
+			fmt.Printf("  new: %s %s\n", keyType, fingerprint)
+
+			if !hostKeyPinYes {
+				if !prompt.IsInteractive() {
+					return fmt.Errorf("refusing to replace the pinned host key without confirmation, use --yes")
+				}
+				ok, err := prompt.Confirm("Replace the pinned host key")
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("host key pin unchanged")
+				}
+			}
+		}
+
+		conn.HostKeyType = keyType
+		conn.HostKeyFingerprint = fingerprint
+		cfg.Connections[alias] = conn
+
+		if err := config.SaveConfig(cfg); err != nil {
+			return fmt.Errorf("error saving config: %w", err)
+		}
+
+		fmt.Printf("Host key of '%s' pinned to %s %s.\n", alias, keyType, fingerprint)
+		return nil
+	},
+}
+
+// hostKeyShowCmd 显示连接的主机密钥信息
+var hostKeyShowCmd = &cobra.Command{
+	Use:   "show [alias]",
+	Short: "Show the pinned and known host keys of a connection",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		alias := args[0]
+
+		conn, err := config.GetConnection(alias)
+		if err != nil {
+			return fmt.Errorf("error getting connection: %w", err)
+		}
+
+		if conn.HostKeyFingerprint != "" {
+			fmt.Printf("Pinned: %s %s\n", conn.HostKeyType, conn.HostKeyFingerprint)
+		} else {
+			fmt.Println("Pinned: none")
+		}
+
+		known, err := ssh.KnownHostKeys(conn)
+		if err != nil {
+			return err
+		}
+		if len(known) == 0 {
+			fmt.Println("Known hosts: none")
+			return nil
+		}
+		fmt.Println("Known hosts:")
+		for _, k := range known {
+			fmt.Printf("  %s %s (%s:%d)\n", k.Key.Type(), gossh.FingerprintSHA256(k.Key), k.Filename, k.Line)
+		}
+		return nil
+	},
+}
+
+// hostKeyForgetCmd 删除连接的主机密钥记录
+var hostKeyForgetCmd = &cobra.Command{
+	Use:   "forget [alias]",
+	Short: "Remove the pinned host key and sshm known_hosts entries of a connection",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		alias := args[0]
+
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			return fmt.Errorf("error loading config: %w", err)
+		}
+
+		conn, exists := cfg.Connections[alias]
+		if !exists {
+			return fmt.Errorf("connection with alias '%s' not found", alias)
+		}
+
+		if conn.HostKeyFingerprint != "" {
+			conn.HostKeyType = ""
+			conn.HostKeyFingerprint = ""
+			cfg.Connections[alias] = conn
+			if err := config.SaveConfig(cfg); err != nil {
+				return fmt.Errorf("error saving config: %w", err)
+			}
+		}
+
+		// 按 connect 实际使用的配置（包括全局默认值）查找记录
+		forgetConn := conn
+		cfg.ApplyDefaults(&forgetConn)
+		removed, err := ssh.ForgetKnownHost(&forgetConn)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Host key of '%s' forgotten (%d known_hosts entries removed).\n", alias, removed)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(hostKeyCmd)
+	hostKeyCmd.AddCommand(hostKeyScanCmd)
+	hostKeyCmd.AddCommand(hostKeyPinCmd)
+	hostKeyCmd.AddCommand(hostKeyShowCmd)
+	hostKeyCmd.AddCommand(hostKeyForgetCmd)
+
+	hostKeyPinCmd.Flags().StringVar(&hostKeyPinFingerprint, "fingerprint", "",
+		"Pin this SHA256 fingerprint instead of scanning the server")
+	hostKeyPinCmd.Flags().StringVar(&hostKeyPinType, "type", "",
+		"Host key type of the given fingerprint (e.g. ssh-ed25519)")
+	hostKeyPinCmd.Flags().BoolVarP(&hostKeyPinYes, "yes", "y", false,
+		"Replace an existing pin without asking for confirmation")
+}
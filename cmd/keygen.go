@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+	"github.com/justseemore/sshm/pkg/ssh"
+	"github.com/spf13/cobra"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+var (
+	// 生成密钥的参数
+	keygenType       string
+	keygenBits       int
+	keygenPassphrase string
+	keygenComment    string
+	keygenUsername   string
+)
+
+// credKeygenCmd 生成密钥对并注册为 key 类型凭证
+var credKeygenCmd = &cobra.Command{
+	Use:   "keygen [alias]",
+	Short: "Generate a key pair and register it as a credential",
+	Long: `Generate an ed25519, rsa or ecdsa key pair in ~/.config/sshm/keys and register
+it as a key credential. The passphrase is asked for in the terminal, or taken
+from --passphrase when there is no terminal. It is stored like --key-password,
+encrypted when the vault is enabled.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		alias := args[0]
+		if err := validateKeyFileName(alias); err != nil {
+			return err
+		}
+
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			return fmt.Errorf("error loading config: %w", err)
+		}
+
+		if _, exists := cfg.Credentials[alias]; exists {
+			return fmt.Errorf("credential with alias '%s' already exists", alias)
+		}
+
+		comment := keygenComment
+		if comment == "" {
+			comment = alias
+		}
+
+		passphrase, err := readNewPassphrase(cmd, keygenPassphrase, "Passphrase for the new key (empty for no passphrase): ")
+		if err != nil {
+			return err
+		}
+
+		key, err := ssh.GenerateKey(keygenType, keygenBits, passphrase, comment)
+		if err != nil {
+			return err
+		}
+
+		// 写入私钥和公钥文件
+		keysDir := config.GetKeysDir()
+		if err := os.MkdirAll(keysDir, 0700); err != nil {
+			return fmt.Errorf("error creating keys directory: %w", err)
+		}
+		keyPath := filepath.Join(keysDir, alias)
+		if err := writeNewFile(keyPath, key.PrivateKey); err != nil {
+			return err
+		}
+		if err := writeNewFile(keyPath+".pub", key.PublicKey); err != nil {
+			os.Remove(keyPath)
+			return err
+		}
+
+		cfg.Credentials[alias] = config.Credential{
+			Type:        "key",
+			Username:    keygenUsername,
+			KeyPath:     keyPath,
+			KeyPassword: passphrase,
+		}
+
+		// 保存失败时删除已生成的文件，避免留下未注册的密钥
+		if err := config.SaveConfig(cfg); err != nil {
+			os.Remove(keyPath)
+			os.Remove(keyPath + ".pub")
+			return fmt.Errorf("error saving config: %w", err)
+		}
+
+		fmt.Printf("Credential '%s' added successfully.\n", alias)
+		fmt.Printf("Private key: %s\n", keyPath)
+		fmt.Printf("Public key:  %s.pub\n", keyPath)
+		fmt.Printf("Fingerprint: %s\n", gossh.FingerprintSHA256(key.Signer.PublicKey()))
+		fmt.Print(string(key.PublicKey))
+		return nil
+	},
+}
+
+// readNewPassphrase 返回新私钥的密码：指定了 --passphrase 时直接使用，否则在终端中输入并确认，
+// 没有终端时返回空字符串。命令行参数会出现在进程列表和 shell 历史中，只作为非交互场景的备选
+func readNewPassphrase(cmd *cobra.Command, flagValue, message string) (string, error) {
+	if cmd.Flags().Changed("passphrase") || !prompt.IsInteractive() {
+		return flagValue, nil
+	}
+
+	passphrase, err := prompt.Password(message)
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", nil
+	}
+	confirm, err := prompt.Password("Confirm passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != confirm {
+		return "", fmt.Errorf("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// validateKeyFileName 检查别名可以直接用作密钥目录中的文件名，不能包含路径分隔符或指向上级目录
+func validateKeyFileName(alias string) error {
+	if alias == "" || alias == "." || alias == ".." || strings.ContainsAny(alias, `/\`) || filepath.Base(alias) != alias {
+		return fmt.Errorf("invalid alias '%s': must not contain path separators or be '.' or '..'", alias)
+	}
+	return nil
+}
+
+// writeNewFile 以 0600 权限创建文件，文件已存在时报错
+func writeNewFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("key file already exists: %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating key file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("error writing key file: %w", err)
+	}
+	return f.Close()
+}
+
+func init() {
+	credCmd.AddCommand(credKeygenCmd)
+
+	credKeygenCmd.Flags().StringVar(&keygenType, "type", ssh.KeyTypeEd25519, "Key type: ed25519, rsa or ecdsa")
+	credKeygenCmd.Flags().IntVar(&keygenBits, "bits", 0, "Key length (rsa: default 3072, ecdsa: 256, 384 or 521)")
+	credKeygenCmd.Flags().StringVar(&keygenPassphrase, "passphrase", "", "Encrypt the private key with this passphrase instead of asking for it (visible in the process list)")
+	credKeygenCmd.Flags().StringVar(&keygenComment, "comment", "", "Public key comment (default: the alias)")
+	credKeygenCmd.Flags().StringVar(&keygenUsername, "username", "", "Username for the credential")
+}
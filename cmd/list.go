@@ -29,6 +29,8 @@ var listCmd = &cobra.Command{
 			proxyInfo := "none"
 			if conn.Proxy != "" {
 				proxyInfo = conn.Proxy
+			} else if conn.ProxyCommand != "" {
+				proxyInfo = "command: " + conn.ProxyCommand
 			}
 			// 修改输出行
 			_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", alias, conn.Host, conn.Port, proxyInfo)
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+// poolCmd 查看和管理连接池
+var poolCmd = &cobra.Command{
+	Use:   "pool",
+	Short: "Inspect and close pooled connections",
+	Long: `Inspect and close the connections kept by the sshm daemon. Without a running
+daemon, connections are not kept between sshm invocations.`,
+}
+
+// poolStatusCmd 列出连接池中的连接
+var poolStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List pooled connections with their usage and traffic",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stats, err := ssh.QueryDaemonPool()
+		if errors.Is(err, ssh.ErrDaemonNotRunning) {
+			fmt.Println("sshm daemon is not running, no connections are pooled. Use 'sshm daemon' to start it.")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(stats) == 0 {
+			fmt.Println("No pooled connections.")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tCONNECTED\tLAST USED\tCHANNELS\tRTT\tSENT\tRECEIVED")
+		for _, s := range stats {
+			rtt := "-"
+			if s.KeepaliveRTT > 0 {
+				rtt = s.KeepaliveRTT.Round(time.Microsecond).String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%d\t%d\n", s.Key,
+				s.Connected.Format("Jan 02 15:04:05"), formatAgo(s.LastUsed),
+				s.Channels, rtt, s.BytesSent, s.BytesReceived)
+		}
+		return w.Flush()
+	},
+}
+
+// poolCloseCmd 关闭连接池中的连接
+var poolCloseCmd = &cobra.Command{
+	Use:   "close <key|all>",
+	Short: "Close a pooled connection, or all of them",
+	Long: `Close the pooled connection with the given key as shown by 'sshm pool status',
+or all pooled connections. Sessions using a closed connection are ended.`,
+	Example: `  sshm pool close "admin@bastion.example.com:22 cred=work"
+  sshm pool close all`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// 键中包含空格，未加引号时按空格拼接
+		key := strings.Join(args, " ")
+		if key == "all" {
+			key = ""
+		}
+		n, err := ssh.CloseDaemonPool(key)
+		if errors.Is(err, ssh.ErrDaemonNotRunning) {
+			fmt.Println("sshm daemon is not running, no connections are pooled.")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Closed %d pooled connection(s).\n", n)
+		return nil
+	},
+}
+
+// formatAgo 以距今时长显示时间
+func formatAgo(t time.Time) string {
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+func init() {
+	rootCmd.AddCommand(poolCmd)
+	poolCmd.AddCommand(poolStatusCmd)
+	poolCmd.AddCommand(poolCloseCmd)
+}
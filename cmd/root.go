@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"github.com/justseemore/sshm/pkg/ssh"
 	"github.com/spf13/cobra"
 )
 
@@ -18,4 +19,6 @@ func Execute() error {
 
 func init() {
 	// Here you will define your flags and configuration settings.
+	rootCmd.PersistentFlags().BoolVarP(&ssh.Verbose, "verbose", "v", false,
+		"Print connection details such as the negotiated algorithms")
 }
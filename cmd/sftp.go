@@ -278,25 +278,19 @@ func downloadDirectory(client *sftp.SftpClient, remotePath, localPath string) er
 // 辅助函数：解析连接和凭证
 func resolveConnectionAndCredential(target string) (*config.Connection, *config.Credential, error) {
 	// 检查是配置别名还是直接IP/主机名
-	isDirectConnect := isIPorHostname(target)
+	isDirectConnect, err := isIPorHostname(target)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	var conn *config.Connection
-	var err error
 
 	if isDirectConnect {
 		// 直接使用IP/主机名连接
-		port := connectPort
-		if port == 0 {
-			port = 22 // 默认SSH端口
-		}
-
-		// 创建临时连接配置
-		conn = &config.Connection{
-			Host: target,
-			Port: port,
-			User: connectUser,
+		conn, err = newDirectConnection(target)
+		if err != nil {
+			return nil, nil, err
 		}
-		// fmt.Println("Direct connection mode - using IP/hostname without saved configuration")
 	} else {
 		// 从配置加载连接别名
 		conn, err = config.GetConnection(target)
@@ -313,8 +307,8 @@ func resolveConnectionAndCredential(target string) (*config.Connection, *config.
 		if err != nil {
 			return nil, nil, fmt.Errorf("error getting credential: %w", err)
 		}
-	} else if !isDirectConnect && conn.DefaultCredential != "" {
-		// 使用连接配置中的默认凭证（仅当使用别名时）
+	} else if !isDirectConnect && conn.DefaultCredential != "" && len(conn.Credentials) == 0 {
+		// 使用连接配置中的默认凭证（仅当使用别名时），配置了凭证链时由连接池依次尝试
 		cred, err = config.GetCredential(conn.DefaultCredential)
 		if err != nil {
 			return nil, nil, fmt.Errorf("error getting default credential: %w", err)
@@ -337,6 +331,10 @@ func resolveConnectionAndCredential(target string) (*config.Connection, *config.
 	// 更新连接用户名
 	conn.User = username
 
+	if len(connectJump) > 0 {
+		conn.Jump = connectJump
+	}
+
 	return conn, cred, nil
 }
 
@@ -354,6 +352,8 @@ func init() {
 			"Port to use when connecting directly to IP/hostname (default: 22)")
 		cmd.Flags().StringVarP(&connectUser, "user", "u", "",
 			"Username to use when connecting directly to IP/hostname")
+		cmd.Flags().StringSliceVarP(&connectJump, "jump", "J", nil,
+			"Connect through these jump host aliases in order (comma separated)")
 	}
 
 	// 添加SFTP特定标志
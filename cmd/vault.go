@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// 解锁缓存有效期
+	vaultUnlockTTL time.Duration
+)
+
+// vaultCmd 管理加密保险库
+var vaultCmd = &cobra.Command{
+	Use:   "vault",
+	Short: "Manage the encrypted secret vault",
+	Long: `Encrypt passwords, key passphrases and proxy credentials in ssh.yaml with a
+key derived from a master password. Secrets are only decrypted in memory.`,
+}
+
+// vaultInitCmd 初始化保险库并加密已有的敏感字段
+var vaultInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Initialize the vault and encrypt existing secrets",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			return fmt.Errorf("error loading config: %w", err)
+		}
+		if cfg.Vault != nil {
+			return fmt.Errorf("vault is already initialized, use 'sshm vault rekey' to change the master password")
+		}
+
+		password, err := readNewMasterPassword()
+		if err != nil {
+			return err
+		}
+
+		if err := config.InitVault(cfg, password); err != nil {
+			return err
+		}
+		if err := config.SaveConfig(cfg); err != nil {
+			return fmt.Errorf("error saving config: %w", err)
+		}
+
+		fmt.Println("Vault initialized. Secrets in the config file are now encrypted.")
+		return nil
+	},
+}
+
+// vaultUnlockCmd 解锁保险库，在有效期内无需重复输入主密码
+var vaultUnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Unlock the vault for a limited time",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readMasterPassword("Vault master password: ")
+		if err != nil {
+			return err
+		}
+
+		if err := config.UnlockVault(password, vaultUnlockTTL); err != nil {
+			return err
+		}
+
+		fmt.Printf("Vault unlocked for %s.\n", vaultUnlockTTL)
+		return nil
+	},
+}
+
+// vaultLockCmd 立即锁定保险库
+var vaultLockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Lock the vault and forget the cached key",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := config.LockVault(); err != nil {
+			return err
+		}
+
+		fmt.Println("Vault locked.")
+		return nil
+	},
+}
+
+// vaultRekeyCmd 更换主密码并重新加密所有敏感字段
+var vaultRekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Change the master password and re-encrypt all secrets",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			return fmt.Errorf("error loading config: %w", err)
+		}
+		if cfg.Vault == nil {
+			return fmt.Errorf("vault is not initialized, run 'sshm vault init'")
+		}
+
+		password, err := readNewMasterPassword()
+		if err != nil {
+			return err
+		}
+
+		if err := config.RekeyVault(cfg, password); err != nil {
+			return err
+		}
+		if err := config.SaveConfig(cfg); err != nil {
+			return fmt.Errorf("error saving config: %w", err)
+		}
+
+		fmt.Println("Vault master password changed.")
+		return nil
+	},
+}
+
+// readMasterPassword 读取主密码，非交互环境下使用 SSHM_VAULT_PASSWORD
+func readMasterPassword(message string) (string, error) {
+	if password, ok := os.LookupEnv("SSHM_VAULT_PASSWORD"); ok {
+		return password, nil
+	}
+	if !prompt.IsInteractive() {
+		return "", fmt.Errorf("no terminal available to read the master password, set SSHM_VAULT_PASSWORD")
+	}
+	return prompt.Password(message)
+}
+
+// readNewMasterPassword 读取新的主密码并要求再次确认
+func readNewMasterPassword() (string, error) {
+	if password, ok := os.LookupEnv("SSHM_VAULT_NEW_PASSWORD"); ok {
+		return password, nil
+	}
+	if !prompt.IsInteractive() {
+		return "", fmt.Errorf("no terminal available to read the new master password, set SSHM_VAULT_NEW_PASSWORD")
+	}
+
+	password, err := prompt.Password("New vault master password: ")
+	if err != nil {
+		return "", err
+	}
+	confirm, err := prompt.Password("Confirm vault master password: ")
+	if err != nil {
+		return "", err
+	}
+	if password != confirm {
+		return "", fmt.Errorf("passwords do not match")
+	}
+	return password, nil
+}
+
+func init() {
+	rootCmd.AddCommand(vaultCmd)
+	vaultCmd.AddCommand(vaultInitCmd)
+	vaultCmd.AddCommand(vaultUnlockCmd)
+	vaultCmd.AddCommand(vaultLockCmd)
+	vaultCmd.AddCommand(vaultRekeyCmd)
+
+	vaultUnlockCmd.Flags().DurationVar(&vaultUnlockTTL, "ttl", config.DefaultUnlockTTL,
+		"How long the vault stays unlocked")
+}
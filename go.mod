@@ -8,6 +8,7 @@ require (
 	github.com/spf13/cobra v1.9.1
 	golang.org/x/crypto v0.40.0
 	golang.org/x/net v0.41.0
+	golang.org/x/term v0.33.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -18,5 +19,4 @@ require (
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
 	golang.org/x/sys v0.34.0 // indirect
-	golang.org/x/term v0.33.0 // indirect
 )
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"net"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 代理配置中的特殊值
+const (
+	// ProxyEnv 表示从 ALL_PROXY/NO_PROXY 环境变量读取代理
+	ProxyEnv = "env"
+	// ProxyNone 表示直接连接，不使用默认代理
+	ProxyNone = "none"
+)
+
+// MatchNoProxy 判断主机是否匹配 no_proxy 模式列表中的任意一项。支持的模式：
+//   - "*" 匹配所有主机
+//   - IP 地址或 CIDR 网段，如 "10.0.0.1"、"10.0.0.0/8"
+//   - 域名，"example.com" 匹配其自身及子域名，".example.com" 和 "*.example.com" 只匹配子域名
+//   - 含有 * 或 ? 的通配符，如 "db-*.internal"
+//
+// 模式可以带端口（如 "example.com:2222"），此时端口也必须相同
+func MatchNoProxy(patterns []string, host string, port int) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	hostAddr, hostErr := netip.ParseAddr(strings.Trim(host, "[]"))
+	// 链路本地地址的 zone 不参与匹配
+	hostAddr = hostAddr.WithZone("").Unmap()
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if pattern == "*" {
+			return true
+		}
+
+		// 拆分可选的端口
+		if h, p, err := net.SplitHostPort(pattern); err == nil {
+			if p != strconv.Itoa(port) {
+				continue
+			}
+			pattern = h
+		}
+		pattern = strings.Trim(pattern, "[]")
+
+		if prefix, err := netip.ParsePrefix(pattern); err == nil {
+			if hostErr == nil && prefix.Contains(hostAddr) {
+				return true
+			}
+			continue
+		}
+		if addr, err := netip.ParseAddr(pattern); err == nil {
+			if hostErr == nil && addr.Unmap() == hostAddr {
+				return true
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(pattern, "*."):
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+		case strings.HasPrefix(pattern, "."):
+			if strings.HasSuffix(host, pattern) {
+				return true
+			}
+		case strings.ContainsAny(pattern, "*?"):
+			if matched, _ := path.Match(pattern, host); matched {
+				return true
+			}
+		default:
+			if host == pattern || strings.HasSuffix(host, "."+pattern) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// State 保存 sshm 运行时记录的状态，与用户编辑的配置文件分开存放
+type State struct {
+	// 每个连接上次连接成功的地址，下次优先尝试
+	LastAddresses map[string]string `yaml:"last_addresses,omitempty"`
+}
+
+// LoadState 读取状态文件，文件不存在时返回空状态
+func LoadState() (*State, error) {
+	state := &State{}
+	data, err := os.ReadFile(GetStatePath())
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("error parsing state file: %w", err)
+	}
+	return state, nil
+}
+
+// SaveState 写入状态文件，先写临时文件再重命名，避免并发运行时读到不完整的内容
+func SaveState(state *State) error {
+	path := GetStatePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("error creating state directory: %w", err)
+	}
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("error serializing state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".state-*.yaml")
+	if err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	return nil
+}
+
+// LastAddress 返回连接上次连接成功的地址，没有记录时返回空字符串
+func LastAddress(key string) string {
+	state, err := LoadState()
+	if err != nil {
+		return ""
+	}
+	return state.LastAddresses[key]
+}
+
+// RememberAddress 记录连接成功的地址，地址未变化时不写文件
+func RememberAddress(key, addr string) error {
+	state, err := LoadState()
+	if err != nil {
+		return err
+	}
+	if state.LastAddresses[key] == addr {
+		return nil
+	}
+	if state.LastAddresses == nil {
+		state.LastAddresses = make(map[string]string)
+	}
+	state.LastAddresses[key] = addr
+	return SaveState(state)
+}
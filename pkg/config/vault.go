@@ -0,0 +1,485 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/prompt"
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// Vault 描述加密保险库的密钥派生参数
+type Vault struct {
+	KDF   string `yaml:"kdf"`   // 密钥派生算法，目前仅支持 "scrypt"
+	Salt  string `yaml:"salt"`  // base64 编码的盐
+	N     int    `yaml:"n"`     // scrypt CPU/内存开销参数
+	R     int    `yaml:"r"`     // scrypt 块大小参数
+	P     int    `yaml:"p"`     // scrypt 并行度参数
+	Check string `yaml:"check"` // 用于校验主密码的密文
+}
+
+const (
+	// 加密字段的前缀，用于区分明文和密文
+	sealedPrefix = "vault:v1:"
+	// 校验主密码时加密的已知明文
+	vaultCheckText = "sshm-vault"
+	// 主密码环境变量，便于非交互场景使用
+	vaultPasswordEnv = "SSHM_VAULT_PASSWORD"
+	// 默认的解锁缓存有效期
+	DefaultUnlockTTL = 15 * time.Minute
+)
+
+// ErrVaultLocked 表示保险库处于锁定状态且无法交互式输入主密码
+var ErrVaultLocked = errors.New("vault is locked, run 'sshm vault unlock' or set " + vaultPasswordEnv)
+
+// ErrWrongMasterPassword 表示主密码错误
+var ErrWrongMasterPassword = errors.New("wrong master password")
+
+// 当前进程中已解锁的保险库密钥，避免同一进程内重复输入主密码。
+// 连接池和守护进程会并发加载配置，字段由 mu 保护
+var unlockedVault struct {
+	mu   sync.Mutex
+	salt string
+	key  []byte
+}
+
+// setUnlockedVault 记录本进程中已解锁的保险库密钥，调用方需持有 unlockedVault.mu
+func setUnlockedVault(salt string, key []byte) {
+	unlockedVault.salt, unlockedVault.key = salt, key
+}
+
+// unlockCache 是解锁缓存文件的内容
+type unlockCache struct {
+	Salt    string    `json:"salt"`
+	Key     []byte    `json:"key"`
+	Expires time.Time `json:"expires"`
+}
+
+// IsSealed 判断字段值是否为保险库密文
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// deriveKey 使用 scrypt 从主密码派生 AES-256 密钥
+func deriveKey(password string, vault *Vault) ([]byte, error) {
+	if vault.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported vault key derivation: %s", vault.KDF)
+	}
+	salt, err := base64.StdEncoding.DecodeString(vault.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vault salt: %w", err)
+	}
+	key, err := scrypt.Key([]byte(password), salt, vault.N, vault.R, vault.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving vault key: %w", err)
+	}
+	return key, nil
+}
+
+// seal 使用 AES-GCM 加密明文
+func seal(key []byte, plaintext string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open 解密 seal 生成的密文
+func open(key []byte, value string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid sealed value: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("invalid sealed value: too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", ErrWrongMasterPassword
+	}
+	return string(plaintext), nil
+}
+
+// verifyKey 校验派生密钥是否与保险库匹配
+func verifyKey(key []byte, vault *Vault) error {
+	check, err := open(key, vault.Check)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(check), []byte(vaultCheckText)) != 1 {
+		return ErrWrongMasterPassword
+	}
+	return nil
+}
+
+// newVault 生成新的保险库参数并返回派生的密钥
+func newVault(password string) (*Vault, []byte, error) {
+	if password == "" {
+		return nil, nil, errors.New("master password must not be empty")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+	vault := &Vault{
+		KDF:  "scrypt",
+		Salt: base64.StdEncoding.EncodeToString(salt),
+		N:    1 << 15,
+		R:    8,
+		P:    1,
+	}
+	key, err := deriveKey(password, vault)
+	if err != nil {
+		return nil, nil, err
+	}
+	if vault.Check, err = seal(key, vaultCheckText); err != nil {
+		return nil, nil, err
+	}
+	return vault, key, nil
+}
+
+// isSecretProxy 判断代理地址是否包含密码
+func isSecretProxy(proxy string) bool {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || proxyURL.User == nil {
+		return false
+	}
+	_, hasPassword := proxyURL.User.Password()
+	return hasPassword
+}
+
+// transformSecrets 对配置中的所有敏感字段执行转换（加密或解密）
+func transformSecrets(config *Config, fn func(value string, secret bool) (string, error)) error {
+	var err error
+	if config.Defaults.Proxy, err = fn(config.Defaults.Proxy, isSecretProxy(config.Defaults.Proxy)); err != nil {
+		return fmt.Errorf("default proxy: %w", err)
+	}
+	for alias, conn := range config.Connections {
+		if conn.Password, err = fn(conn.Password, true); err != nil {
+			return fmt.Errorf("connection '%s' password: %w", alias, err)
+		}
+		if conn.Proxy, err = fn(conn.Proxy, isSecretProxy(conn.Proxy)); err != nil {
+			return fmt.Errorf("connection '%s' proxy: %w", alias, err)
+		}
+		config.Connections[alias] = conn
+	}
+	for alias, cred := range config.Credentials {
+		if cred.Password, err = fn(cred.Password, true); err != nil {
+			return fmt.Errorf("credential '%s' password: %w", alias, err)
+		}
+		if cred.KeyPassword, err = fn(cred.KeyPassword, true); err != nil {
+			return fmt.Errorf("credential '%s' key password: %w", alias, err)
+		}
+		if cred.TOTPSecret, err = fn(cred.TOTPSecret, true); err != nil {
+			return fmt.Errorf("credential '%s' TOTP secret: %w", alias, err)
+		}
+		// 复制规则列表，避免修改与原配置共享的底层数组
+		if len(cred.Challenges) > 0 {
+			challenges := make([]Challenge, len(cred.Challenges))
+			for i, challenge := range cred.Challenges {
+				if challenge.Answer, err = fn(challenge.Answer, strings.HasPrefix(challenge.Answer, "text:")); err != nil {
+					return fmt.Errorf("credential '%s' challenge answer: %w", alias, err)
+				}
+				challenges[i] = challenge
+			}
+			cred.Challenges = challenges
+		}
+		config.Credentials[alias] = cred
+	}
+	return nil
+}
+
+// openSecrets 解密配置中的所有密文字段
+func (c *Config) openSecrets() error {
+	return transformSecrets(c, func(value string, _ bool) (string, error) {
+		if !IsSealed(value) {
+			return value, nil
+		}
+		return open(c.vaultKey, value)
+	})
+}
+
+// sealedCopy 返回所有敏感字段均已加密的配置副本，原配置保持明文
+func (c *Config) sealedCopy() (*Config, error) {
+	sealed := &Config{
+		Vault:       c.Vault,
+		Defaults:    c.Defaults,
+		Connections: make(map[string]Connection, len(c.Connections)),
+		Credentials: make(map[string]Credential, len(c.Credentials)),
+	}
+	for alias, conn := range c.Connections {
+		sealed.Connections[alias] = conn
+	}
+	for alias, cred := range c.Credentials {
+		sealed.Credentials[alias] = cred
+	}
+
+	err := transformSecrets(sealed, func(value string, secret bool) (string, error) {
+		if !secret || value == "" || IsSealed(value) {
+			return value, nil
+		}
+		return seal(c.vaultKey, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+// unlockCachePath 返回解锁缓存文件路径，优先使用 XDG_RUNTIME_DIR（通常为内存文件系统）
+func unlockCachePath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf("sshm-%d", os.Getuid()), "vault.unlock")
+}
+
+// readUnlockCache 从解锁缓存读取未过期的密钥
+func readUnlockCache(vault *Vault) []byte {
+	data, err := os.ReadFile(unlockCachePath())
+	if err != nil {
+		return nil
+	}
+	var cache unlockCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil
+	}
+	if cache.Salt != vault.Salt || time.Now().After(cache.Expires) {
+		return nil
+	}
+	if verifyKey(cache.Key, vault) != nil {
+		return nil
+	}
+	return cache.Key
+}
+
+// writeUnlockCache 将密钥写入权限为 0600 的解锁缓存。先写入临时文件再重命名，
+// 不会跟随其他用户在目录中预先放置的符号链接
+func writeUnlockCache(vault *Vault, key []byte, ttl time.Duration) error {
+	path := unlockCachePath()
+	if err := ensurePrivateDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	data, err := json.Marshal(unlockCache{
+		Salt:    vault.Salt,
+		Key:     key,
+		Expires: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	// CreateTemp 以 O_EXCL 和 0600 权限创建文件
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".vault.unlock-*")
+	if err != nil {
+		return fmt.Errorf("error writing unlock cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing unlock cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing unlock cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error writing unlock cache: %w", err)
+	}
+	return nil
+}
+
+// ensurePrivateDir 创建解锁缓存目录，并确认它是属于当前用户、权限为 0700 的真实目录。
+// 缓存目录可能位于所有用户共享的临时目录中，其他用户预先创建的目录或符号链接会被拒绝
+func ensurePrivateDir(dir string) error {
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("error creating unlock cache directory: %w", err)
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("error checking unlock cache directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("unlock cache directory %s is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("unlock cache directory %s is not owned by the current user", dir)
+	}
+	if info.Mode().Perm() != 0700 {
+		return fmt.Errorf("unlock cache directory %s must have permissions 0700, has %04o", dir, info.Mode().Perm())
+	}
+	return nil
+}
+
+// unlockVaultKey 依次从进程缓存、解锁缓存、环境变量和交互式输入获取保险库密钥
+func unlockVaultKey(vault *Vault) ([]byte, error) {
+	// 持有锁直到解锁完成，并发加载配置时只输入一次主密码
+	unlockedVault.mu.Lock()
+	defer unlockedVault.mu.Unlock()
+	if unlockedVault.salt == vault.Salt && unlockedVault.key != nil {
+		return unlockedVault.key, nil
+	}
+
+	key := readUnlockCache(vault)
+	if key == nil {
+		password, ok := os.LookupEnv(vaultPasswordEnv)
+		if !ok {
+			if !prompt.IsInteractive() {
+				return nil, ErrVaultLocked
+			}
+			var err error
+			if password, err = prompt.Password("Vault master password: "); err != nil {
+				return nil, err
+			}
+		}
+
+		var err error
+		if key, err = deriveKey(password, vault); err != nil {
+			return nil, err
+		}
+		if err := verifyKey(key, vault); err != nil {
+			return nil, err
+		}
+	}
+
+	setUnlockedVault(vault.Salt, key)
+	return key, nil
+}
+
+// readVaultHeader 读取配置文件中的保险库参数而不解锁
+func readVaultHeader() (*Vault, error) {
+	data, err := os.ReadFile(GetConfigPath())
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	var header struct {
+		Vault *Vault `yaml:"vault"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("error parsing config file: %w", err)
+	}
+	if header.Vault == nil {
+		return nil, errors.New("vault is not initialized, run 'sshm vault init'")
+	}
+	return header.Vault, nil
+}
+
+// InitVault 为配置启用保险库，保存配置时敏感字段将被加密
+func InitVault(config *Config, password string) error {
+	if config.Vault != nil {
+		return errors.New("vault is already initialized")
+	}
+	vault, key, err := newVault(password)
+	if err != nil {
+		return err
+	}
+	config.Vault = vault
+	config.vaultKey = key
+	unlockedVault.mu.Lock()
+	setUnlockedVault(vault.Salt, key)
+	unlockedVault.mu.Unlock()
+	return nil
+}
+
+// RekeyVault 使用新的主密码重新生成保险库密钥，保存配置时以新密钥加密
+func RekeyVault(config *Config, password string) error {
+	if config.Vault == nil {
+		return errors.New("vault is not initialized, run 'sshm vault init'")
+	}
+	vault, key, err := newVault(password)
+	if err != nil {
+		return err
+	}
+	// 旧密钥的解锁缓存已失效
+	if err := LockVault(); err != nil {
+		return err
+	}
+
+	config.Vault = vault
+	config.vaultKey = key
+	unlockedVault.mu.Lock()
+	setUnlockedVault(vault.Salt, key)
+	unlockedVault.mu.Unlock()
+	return nil
+}
+
+// UnlockVault 校验主密码并写入有效期为 ttl 的解锁缓存
+func UnlockVault(password string, ttl time.Duration) error {
+	vault, err := readVaultHeader()
+	if err != nil {
+		return err
+	}
+	key, err := deriveKey(password, vault)
+	if err != nil {
+		return err
+	}
+	if err := verifyKey(key, vault); err != nil {
+		return err
+	}
+	return writeUnlockCache(vault, key, ttl)
+}
+
+// UnlockedVaultKey 返回本进程中已解锁的保险库密钥，未解锁时返回 nil
+func UnlockedVaultKey() []byte {
+	unlockedVault.mu.Lock()
+	defer unlockedVault.mu.Unlock()
+	return unlockedVault.key
+}
+
+// UseVaultKey 校验并在本进程中使用已解锁的保险库密钥，用于后台守护进程接收启动它的 sshm 解锁的密钥
+func UseVaultKey(key []byte) error {
+	vault, err := readVaultHeader()
+	if err != nil {
+		return err
+	}
+	if err := verifyKey(key, vault); err != nil {
+		return err
+	}
+	unlockedVault.mu.Lock()
+	setUnlockedVault(vault.Salt, key)
+	unlockedVault.mu.Unlock()
+	return nil
+}
+
+// LockVault 删除解锁缓存
+func LockVault() error {
+	unlockedVault.mu.Lock()
+	setUnlockedVault("", nil)
+	unlockedVault.mu.Unlock()
+	if err := os.Remove(unlockCachePath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing unlock cache: %w", err)
+	}
+	return nil
+}
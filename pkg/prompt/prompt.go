@@ -0,0 +1,113 @@
+package prompt
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// 共享的标准输入读取器，避免多次提示时丢失缓冲数据
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// Prompter 提供交互式输入，默认使用本进程的终端。后台守护进程为每个请求使用转发到发起请求的 sshm 进程的 Prompter
+type Prompter interface {
+	IsInteractive() bool
+	Line(message string) (string, error)
+	Password(message string) (string, error)
+	Print(message string)
+}
+
+var (
+	prompterMu sync.RWMutex
+	current    Prompter = terminal{}
+)
+
+// SetPrompter 替换进程默认使用的 Prompter，返回恢复原值的函数
+func SetPrompter(p Prompter) (restore func()) {
+	prompterMu.Lock()
+	previous := current
+	current = p
+	prompterMu.Unlock()
+	return func() {
+		prompterMu.Lock()
+		current = previous
+		prompterMu.Unlock()
+	}
+}
+
+// Current 返回进程默认使用的 Prompter
+func Current() Prompter {
+	prompterMu.RLock()
+	defer prompterMu.RUnlock()
+	return current
+}
+
+// IsInteractive 判断是否可以交互式输入（默认即标准输入是否为终端）
+func IsInteractive() bool {
+	return Current().IsInteractive()
+}
+
+// Line 输出提示信息并读取一行输入
+func Line(message string) (string, error) {
+	return Current().Line(message)
+}
+
+// Password 输出提示信息并以不回显的方式读取密码
+func Password(message string) (string, error) {
+	return Current().Password(message)
+}
+
+// Confirm 询问是/否问题，只有明确输入 yes 或 y 才返回 true
+func Confirm(question string) (bool, error) {
+	return ConfirmWith(Current(), question)
+}
+
+// ConfirmWith 使用指定的 Prompter 询问是/否问题
+func ConfirmWith(p Prompter, question string) (bool, error) {
+	answer, err := p.Line(question + " (yes/no)? ")
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "yes", "y":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// terminal 使用本进程的标准输入和标准错误输出
+type terminal struct{}
+
+func (terminal) IsInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+func (terminal) Print(message string) {
+	fmt.Fprint(os.Stderr, message)
+}
+
+// Line 在标准错误输出提示信息并读取一行输入
+func (terminal) Line(message string) (string, error) {
+	fmt.Fprint(os.Stderr, message)
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("unable to read input: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// Password 在标准错误输出提示信息并以不回显的方式读取密码
+func (terminal) Password(message string) (string, error) {
+	fmt.Fprint(os.Stderr, message)
+	secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("unable to read password: %w", err)
+	}
+	return string(secret), nil
+}
@@ -0,0 +1,214 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AuthorizedKey 表示 authorized_keys 文件中的一个公钥条目
+type AuthorizedKey struct {
+	LineNumber int           // 所在行号（从 1 开始）
+	Key        ssh.PublicKey // 公钥
+	Comment    string        // 公钥注释
+	Options    []string      // 公钥选项，如 from="..."、no-pty
+}
+
+// ParseAuthorizedKeys 解析 authorized_keys 内容，跳过空行、注释和无法解析的行
+func ParseAuthorizedKeys(data []byte) []AuthorizedKey {
+	var keys []AuthorizedKey
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		key, comment, options, _, err := ssh.ParseAuthorizedKey([]byte(trimmed))
+		if err != nil {
+			continue
+		}
+		keys = append(keys, AuthorizedKey{
+			LineNumber: i + 1,
+			Key:        key,
+			Comment:    comment,
+			Options:    options,
+		})
+	}
+	return keys
+}
+
+// sshDir 返回远程用户的 ~/.ssh 目录
+func (c *SftpClient) sshDir() (string, error) {
+	home, err := c.sftpClient.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("unable to determine remote home directory: %w", err)
+	}
+	return path.Join(home, ".ssh"), nil
+}
+
+// ReadAuthorizedKeys 读取远程 ~/.ssh/authorized_keys，文件不存在时返回空内容
+func (c *SftpClient) ReadAuthorizedKeys() ([]byte, error) {
+	dir, err := c.sshDir()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := c.sftpClient.Open(path.Join(dir, "authorized_keys"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open authorized_keys: %w", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorized_keys: %w", err)
+	}
+	return data, nil
+}
+
+// WriteAuthorizedKeys 替换远程 ~/.ssh/authorized_keys。
+// 不存在的 .ssh 目录以 0700 创建，文件以 0600 写入临时文件后再重命名，避免写到一半时锁死登录
+func (c *SftpClient) WriteAuthorizedKeys(data []byte) error {
+	dir, err := c.sshDir()
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.sftpClient.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := c.sftpClient.Mkdir(dir); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+		if err := c.sftpClient.Chmod(dir, 0700); err != nil {
+			return fmt.Errorf("failed to set permissions on %s: %w", dir, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", dir, err)
+	}
+
+	target := path.Join(dir, "authorized_keys")
+	tmp := target + ".sshm-tmp"
+	f, err := c.sftpClient.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		c.sftpClient.Remove(tmp)
+		return fmt.Errorf("failed to set permissions on %s: %w", tmp, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		c.sftpClient.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := f.Close(); err != nil {
+		c.sftpClient.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+
+	// 优先使用原子替换，服务器不支持 posix-rename 扩展时退回到先移走原文件再重命名
+	if err := c.sftpClient.PosixRename(tmp, target); err != nil {
+		if err := c.replaceWithBackup(tmp, target); err != nil {
+			c.sftpClient.Remove(tmp)
+			return fmt.Errorf("failed to replace authorized_keys: %w", err)
+		}
+	}
+	return nil
+}
+
+// replaceWithBackup 将原文件移到备份位置后把 tmp 重命名为 target，失败时恢复原文件。
+// 任何时候 target 或其备份至少有一个存在，不会因替换失败丢失 authorized_keys
+func (c *SftpClient) replaceWithBackup(tmp, target string) error {
+	backup := target + ".sshm-bak"
+	_, err := c.sftpClient.Stat(target)
+	hasOriginal := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if hasOriginal {
+		// SFTP 的重命名不覆盖已有文件，先清理上次遗留的备份
+		c.sftpClient.Remove(backup)
+		if err := c.sftpClient.Rename(target, backup); err != nil {
+			return err
+		}
+	}
+	if err := c.sftpClient.Rename(tmp, target); err != nil {
+		if hasOriginal {
+			if restoreErr := c.sftpClient.Rename(backup, target); restoreErr != nil {
+				return fmt.Errorf("%w; the original file was kept as %s: %v", err, backup, restoreErr)
+			}
+		}
+		return err
+	}
+	if hasOriginal {
+		c.sftpClient.Remove(backup)
+	}
+	return nil
+}
+
+// AddAuthorizedKey 将公钥追加到远程 authorized_keys，公钥已存在时返回 false
+func (c *SftpClient) AddAuthorizedKey(key ssh.PublicKey, comment string) (bool, error) {
+	data, err := c.ReadAuthorizedKeys()
+	if err != nil {
+		return false, err
+	}
+
+	for _, existing := range ParseAuthorizedKeys(data) {
+		if bytes.Equal(existing.Key.Marshal(), key.Marshal()) {
+			return false, nil
+		}
+	}
+
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	line := bytes.TrimSuffix(ssh.MarshalAuthorizedKey(key), []byte("\n"))
+	if comment != "" {
+		line = append(line, []byte(" "+comment)...)
+	}
+	data = append(append(data, line...), '\n')
+
+	if err := c.WriteAuthorizedKeys(data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RemoveAuthorizedKeys 删除远程 authorized_keys 中满足条件的公钥，返回删除的条目数
+func (c *SftpClient) RemoveAuthorizedKeys(match func(AuthorizedKey) bool) (int, error) {
+	data, err := c.ReadAuthorizedKeys()
+	if err != nil {
+		return 0, err
+	}
+
+	remove := make(map[int]bool)
+	for _, key := range ParseAuthorizedKeys(data) {
+		if match(key) {
+			remove[key.LineNumber] = true
+		}
+	}
+	if len(remove) == 0 {
+		return 0, nil
+	}
+
+	var kept []string
+	for i, line := range strings.Split(string(data), "\n") {
+		if !remove[i+1] {
+			kept = append(kept, line)
+		}
+	}
+
+	if err := c.WriteAuthorizedKeys([]byte(strings.Join(kept, "\n"))); err != nil {
+		return 0, err
+	}
+	return len(remove), nil
+}
@@ -18,13 +18,15 @@ import (
 type SftpClient struct {
 	sshClient  *ssh.Client
 	sftpClient *sftp.Client
+	// 释放对连接池中连接的占用
+	release func()
 }
 
 // NewSftpClient 创建新的SFTP客户端
 func NewSftpClient(conn *config.Connection, cred *config.Credential) (*SftpClient, error) {
 	// 从连接池获取或创建SSH客户端
 	pool := ssh_pool.GetConnectionPool()
-	client, err := pool.GetClient(conn, cred)
+	client, release, err := pool.AcquireClient(conn, cred)
 	if err != nil {
 		return nil, fmt.Errorf("unable to establish SSH connection: %w", err)
 	}
@@ -32,17 +34,22 @@ func NewSftpClient(conn *config.Connection, cred *config.Credential) (*SftpClien
 	// 创建SFTP客户端
 	sftpClient, err := sftp.NewClient(client)
 	if err != nil {
+		release()
 		return nil, fmt.Errorf("unable to create SFTP client: %w", err)
 	}
 
 	return &SftpClient{
 		sshClient:  client,
 		sftpClient: sftpClient,
+		release:    release,
 	}, nil
 }
 
 // Close 关闭SFTP和SSH连接
 func (c *SftpClient) Close() error {
+	if c.release != nil {
+		defer c.release()
+	}
 	if c.sftpClient != nil {
 		return c.sftpClient.Close()
 	}
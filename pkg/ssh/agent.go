@@ -0,0 +1,110 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentAvailable 判断是否设置了 SSH_AUTH_SOCK
+func agentAvailable() bool {
+	return os.Getenv("SSH_AUTH_SOCK") != ""
+}
+
+// dialAgent 连接 SSH_AUTH_SOCK 指向的 ssh-agent
+func dialAgent() (agent.ExtendedAgent, io.Closer, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, errors.New("SSH_AUTH_SOCK is not set, no ssh-agent available")
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to ssh-agent: %w", err)
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// agentSigners 返回 ssh-agent 中的签名器，filter 非空时只返回注释或 SHA256 指纹匹配的密钥，
+// 避免密钥过多时因超过服务器的认证尝试次数而被拒绝
+func agentSigners(client agent.ExtendedAgent, filter string) ([]ssh.Signer, error) {
+	signers, err := client.Signers()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list ssh-agent keys: %w", err)
+	}
+	if filter == "" {
+		return signers, nil
+	}
+
+	keys, err := client.List()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list ssh-agent keys: %w", err)
+	}
+	comments := make(map[string]string, len(keys))
+	for _, key := range keys {
+		comments[string(key.Marshal())] = key.Comment
+	}
+
+	var matched []ssh.Signer
+	for _, signer := range signers {
+		pub := signer.PublicKey()
+		if comments[string(pub.Marshal())] == filter || ssh.FingerprintSHA256(pub) == filter {
+			matched = append(matched, signer)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no key in ssh-agent matches '%s'", filter)
+	}
+	return matched, nil
+}
+
+// 已注册 agent 转发处理的连接，同一个池化连接只能注册一次
+var (
+	forwardedClients   = make(map[*ssh.Client]bool)
+	forwardedClientsMu sync.Mutex
+)
+
+// requestAgentForwarding 在池化连接上注册 agent 转发，并为会话请求转发
+func requestAgentForwarding(client *ssh.Client, session *ssh.Session, conn *config.Connection) error {
+	if slices.Contains(conn.Tags, config.UntrustedTag) {
+		fmt.Fprintf(os.Stderr, "Warning: forwarding your ssh-agent to untrusted host %s, "+
+			"anyone with root access there can use your keys while you are connected\n", conn.Host)
+	}
+
+	forwardedClientsMu.Lock()
+	defer forwardedClientsMu.Unlock()
+
+	if !forwardedClients[client] {
+		keyring, closer, err := dialAgent()
+		if err != nil {
+			return err
+		}
+		if err := agent.ForwardToAgent(client, keyring); err != nil {
+			closer.Close()
+			return fmt.Errorf("unable to forward ssh-agent: %w", err)
+		}
+		forwardedClients[client] = true
+
+		// 连接关闭后释放 agent 连接
+		go func() {
+			client.Wait()
+			closer.Close()
+			forwardedClientsMu.Lock()
+			delete(forwardedClients, client)
+			forwardedClientsMu.Unlock()
+		}()
+	}
+
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		return fmt.Errorf("unable to request agent forwarding: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// Verbose 为 true 时在标准错误输出连接过程的调试信息
+var Verbose bool
+
+// debugf 在详细模式下输出调试信息
+func debugf(format string, args ...any) {
+	if Verbose {
+		fmt.Fprintf(os.Stderr, "debug: "+format+"\n", args...)
+	}
+}
+
+// AlgorithmCategory 描述一类算法的配置项名称及 x/crypto 支持的取值
+type AlgorithmCategory struct {
+	Name      string   // 配置项名称，如 "ciphers"
+	Supported []string // 默认启用的算法（按优先顺序）
+	Insecure  []string // 存在安全问题、需要显式配置才会启用的旧算法
+}
+
+// AlgorithmCategories 返回各类算法支持的取值
+func AlgorithmCategories() []AlgorithmCategory {
+	supported := ssh.SupportedAlgorithms()
+	insecure := ssh.InsecureAlgorithms()
+	return []AlgorithmCategory{
+		{"ciphers", supported.Ciphers, insecure.Ciphers},
+		{"kex_algorithms", supported.KeyExchanges, insecure.KeyExchanges},
+		{"macs", supported.MACs, insecure.MACs},
+		{"host_key_algorithms", supported.HostKeys, insecure.HostKeys},
+	}
+}
+
+// ValidateAlgorithms 校验连接配置的算法是否被 x/crypto 支持，包括不安全但仍可启用的旧算法
+func ValidateAlgorithms(conn *config.Connection) error {
+	lists := [][]string{conn.Ciphers, conn.KexAlgorithms, conn.MACs, conn.HostKeyAlgorithms}
+	for i, category := range AlgorithmCategories() {
+		for _, algo := range lists[i] {
+			if !slices.Contains(category.Supported, algo) && !slices.Contains(category.Insecure, algo) {
+				return fmt.Errorf("unsupported %s entry '%s', run 'sshm algorithms' to list the supported values", category.Name, algo)
+			}
+		}
+	}
+	return nil
+}
+
+// applyAlgorithms 将连接的算法策略写入客户端配置，算法列表需已通过 ValidateAlgorithms 校验
+func applyAlgorithms(conn *config.Connection, clientConfig *ssh.ClientConfig, hostname string) {
+	clientConfig.Ciphers = conn.Ciphers
+	clientConfig.KeyExchanges = conn.KexAlgorithms
+	clientConfig.MACs = conn.MACs
+
+	known := hostKeyAlgorithms(conn, hostname)
+	if len(conn.HostKeyAlgorithms) == 0 {
+		clientConfig.HostKeyAlgorithms = known
+		return
+	}
+
+	// 在配置的范围内优先使用 known_hosts 中已记录的密钥类型，避免协商出未记录的密钥
+	var preferred, rest []string
+	for _, algo := range conn.HostKeyAlgorithms {
+		if slices.Contains(known, algo) {
+			preferred = append(preferred, algo)
+		} else {
+			rest = append(rest, algo)
+		}
+	}
+	clientConfig.HostKeyAlgorithms = append(preferred, rest...)
+}
+
+// logNegotiatedAlgorithms 在详细模式下输出握手协商的算法
+func logNegotiatedAlgorithms(client *ssh.Client) {
+	if !Verbose {
+		return
+	}
+	meta, ok := client.Conn.(ssh.AlgorithmsConnMetadata)
+	if !ok {
+		return
+	}
+	algos := meta.Algorithms()
+	debugf("negotiated kex=%s hostkey=%s", algos.KeyExchange, algos.HostKey)
+	debugf("negotiated client->server cipher=%s mac=%s", algos.Write.Cipher, macOrImplicit(algos.Write))
+	debugf("negotiated server->client cipher=%s mac=%s", algos.Read.Cipher, macOrImplicit(algos.Read))
+}
+
+// macOrImplicit 返回 MAC 算法，AEAD 加密算法不单独协商 MAC
+func macOrImplicit(d ssh.DirectionAlgorithms) string {
+	if d.MAC == "" || strings.Contains(d.Cipher, "gcm") || strings.Contains(d.Cipher, "poly1305") {
+		return "<implicit>"
+	}
+	return d.MAC
+}
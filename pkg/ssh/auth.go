@@ -0,0 +1,324 @@
+package ssh
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+	"golang.org/x/crypto/ssh"
+)
+
+// authConfig 保存一次握手使用的用户名、认证方法以及握手结束后需要释放的资源
+type authConfig struct {
+	user    string
+	methods []ssh.AuthMethod
+	closers []io.Closer
+	// 认证成功后执行的操作，如保留交互式输入的密码
+	onSuccess []func()
+	// 本次握手中各缓存键最后一次提供的密码
+	secrets map[string]*providedSecret
+	// 输入密码和验证码使用的 Prompter
+	prompter prompt.Prompter
+}
+
+// succeeded 在握手成功后调用，执行延后的操作
+func (a *authConfig) succeeded() {
+	for _, fn := range a.onSuccess {
+		fn()
+	}
+	a.onSuccess = nil
+}
+
+// Close 释放认证过程中打开的资源（如 ssh-agent 连接）
+func (a *authConfig) Close() {
+	for _, c := range a.closers {
+		c.Close()
+	}
+}
+
+// newAuthConfig 根据凭证或连接配置构建认证方法，需要输入的密码和验证码通过 pr 获取
+func newAuthConfig(conn *config.Connection, cred *config.Credential, pr prompt.Prompter) (*authConfig, error) {
+	auth := &authConfig{user: conn.User, prompter: pr}
+	addr := connectionAddr(conn)
+
+	// 使用凭证中的认证信息（如果提供）
+	if cred != nil {
+		// 使用凭证中的用户名（如果有）
+		if cred.Username != "" {
+			auth.user = cred.Username
+		}
+
+		// 根据凭证类型添加认证方法
+		switch cred.Type {
+		case "key":
+			// 添加私钥认证
+			signer, err := auth.keyCredentialSigner(cred)
+			if err != nil {
+				return nil, err
+			}
+			auth.methods = append(auth.methods, ssh.PublicKeys(signer))
+		case "password":
+			// 添加密码认证
+			auth.methods = append(auth.methods, auth.credentialPasswordMethod(cred, addr))
+		case "agent":
+			// 通过 ssh-agent 认证
+			if err := auth.addAgent(cred.AgentKey); err != nil {
+				auth.Close()
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported credential type: %s", cred.Type)
+		}
+
+		// keyboard-interactive 放在最后，用于密码登录和双因素认证
+		auth.methods = append(auth.methods, ssh.KeyboardInteractive(keyboardInteractive(cred, pr)))
+		return auth, nil
+	}
+
+	// 使用连接配置中的认证信息
+	if conn.Password != "" {
+		auth.methods = append(auth.methods, ssh.Password(conn.Password))
+	}
+
+	if conn.IdentityFile != "" {
+		signer, err := auth.identitySigner(conn)
+		if err != nil {
+			return nil, err
+		}
+		auth.methods = append(auth.methods, ssh.PublicKeys(signer))
+	}
+
+	// 没有配置任何认证信息时默认使用 ssh-agent，agent 不可用时忽略
+	if len(auth.methods) == 0 && agentAvailable() {
+		_ = auth.addAgent("")
+	}
+
+	// 没有保存密码时，在终端中输入密码作为最后的手段
+	if conn.Password == "" && pr.IsInteractive() {
+		auth.methods = append(auth.methods, auth.connectionPasswordMethod(conn, addr))
+	}
+
+	auth.methods = append(auth.methods, ssh.KeyboardInteractive(keyboardInteractive(&config.Credential{
+		Password: conn.Password,
+	}, pr)))
+
+	return auth, nil
+}
+
+// newKeyAuthConfig 只使用 key 类型凭证的私钥（或证书）认证，不附加 keyboard-interactive 和密码输入，
+// 用于确认该密钥本身可以登录
+func newKeyAuthConfig(conn *config.Connection, cred *config.Credential, pr prompt.Prompter) (*authConfig, error) {
+	if cred.Type != "key" {
+		return nil, fmt.Errorf("credential type '%s' has no key file, only key logins can be verified", cred.Type)
+	}
+	auth := &authConfig{user: conn.User, prompter: pr}
+	if cred.Username != "" {
+		auth.user = cred.Username
+	}
+	signer, err := auth.keyCredentialSigner(cred)
+	if err != nil {
+		return nil, err
+	}
+	auth.methods = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	return auth, nil
+}
+
+// addAgent 添加 ssh-agent 认证，filter 非空时只使用匹配注释或指纹的密钥
+func (a *authConfig) addAgent(filter string) error {
+	client, closer, err := dialAgent()
+	if err != nil {
+		return err
+	}
+	a.closers = append(a.closers, closer)
+	a.methods = append(a.methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		return agentSigners(client, filter)
+	}))
+	return nil
+}
+
+// 交互式输入密码的最大尝试次数
+const maxPasswordAttempts = 3
+
+// credentialSigner 加载凭证的私钥
+func (a *authConfig) credentialSigner(cred *config.Credential) (ssh.Signer, error) {
+	return loadSigner(cred.KeyPath, a.credentialPassphrase(cred))
+}
+
+// keyCredentialSigner 加载 key 类型凭证的私钥，配置了证书时返回证书签名器
+func (a *authConfig) keyCredentialSigner(cred *config.Credential) (ssh.Signer, error) {
+	signer, err := a.credentialSigner(cred)
+	if err != nil {
+		return nil, err
+	}
+	if cred.CertPath != "" {
+		return certificateSigner(cred.CertPath, signer)
+	}
+	return signer, nil
+}
+
+// credentialPassphrase 返回获取凭证私钥密码的函数，只在私钥已加密时调用：
+// 依次使用已保存的密码、密码命令和交互式输入
+func (a *authConfig) credentialPassphrase(cred *config.Credential) func(retry bool) (string, error) {
+	stored := cred.KeyPassword != "" || cred.KeyPasswordCommand != ""
+	return func(retry bool) (string, error) {
+		if stored {
+			if retry {
+				return "", nil
+			}
+			return credentialKeyPassword(cred)
+		}
+		return a.promptSecret(secretRequest{
+			cacheKey: "key:" + os.ExpandEnv(cred.KeyPath),
+			message:  fmt.Sprintf("Enter passphrase for key '%s': ", cred.KeyPath),
+			remember: cred.RememberSecret,
+			save:     credentialSaver(cred, func(c *config.Credential, secret string) { c.KeyPassword = secret }),
+			hint:     fmt.Sprintf("private key %s is encrypted, set key_password or key_password_command, or connect from a terminal", cred.KeyPath),
+		})
+	}
+}
+
+// identitySigner 加载连接配置中的身份文件，私钥已加密时交互式输入密码
+func (a *authConfig) identitySigner(conn *config.Connection) (ssh.Signer, error) {
+	return loadSigner(conn.IdentityFile, func(retry bool) (string, error) {
+		return a.promptSecret(secretRequest{
+			cacheKey: "key:" + os.ExpandEnv(conn.IdentityFile),
+			message:  fmt.Sprintf("Enter passphrase for key '%s': ", conn.IdentityFile),
+			// 身份文件没有对应的密码字段，只能在进程内保留
+			remember: rememberInProcess(conn.RememberSecret),
+			hint:     fmt.Sprintf("private key %s is encrypted, use a key credential with key_password, or connect from a terminal", conn.IdentityFile),
+		})
+	})
+}
+
+// loadSigner 读取并解析私钥文件，私钥已加密时通过 passphrase 获取密码；
+// retry 为 true 表示上一次的密码错误，passphrase 返回空字符串表示放弃
+func loadSigner(path string, passphrase func(retry bool) (string, error)) (ssh.Signer, error) {
+	key, err := loadPrivateKey(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key: %w", err)
+	}
+	return signer, nil
+}
+
+// loadPrivateKey 读取并解析 OpenSSH、PEM 或 PuTTY .ppk 格式的私钥，返回原始私钥
+func loadPrivateKey(path string, passphrase func(retry bool) (string, error)) (crypto.PrivateKey, error) {
+	expandedPath := os.ExpandEnv(path)
+	data, err := os.ReadFile(expandedPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private key: %w", err)
+	}
+
+	parse := func(password []byte) (crypto.PrivateKey, error) {
+		if IsPPK(data) {
+			key, _, err := ParsePPK(data, password)
+			return key, err
+		}
+		if password == nil {
+			return ssh.ParseRawPrivateKey(data)
+		}
+		return ssh.ParseRawPrivateKeyWithPassphrase(data, password)
+	}
+
+	key, err := parse(nil)
+	var missingErr *ssh.PassphraseMissingError
+	if errors.As(err, &missingErr) && passphrase != nil {
+		for attempt := 0; attempt < maxPasswordAttempts; attempt++ {
+			password, perr := passphrase(attempt > 0)
+			if perr != nil {
+				return nil, perr
+			}
+			if password == "" {
+				break
+			}
+			key, err = parse([]byte(password))
+			if !errors.Is(err, x509.IncorrectPasswordError) {
+				break
+			}
+		}
+	}
+	if errors.As(err, &missingErr) {
+		return nil, fmt.Errorf("private key %s is encrypted and no passphrase was provided", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key: %w", err)
+	}
+
+	return key, nil
+}
+
+// credentialPasswordMethod 返回凭证的密码认证方法：
+// 已保存密码或密码命令时只尝试一次，否则交互式输入并允许重试
+func (a *authConfig) credentialPasswordMethod(cred *config.Credential, addr string) ssh.AuthMethod {
+	if cred.Password != "" || cred.PasswordCommand != "" {
+		// 密码命令只在服务器要求密码时执行
+		return ssh.PasswordCallback(func() (string, error) {
+			return credentialPassword(cred)
+		})
+	}
+
+	return ssh.RetryableAuthMethod(ssh.PasswordCallback(func() (string, error) {
+		return a.promptSecret(secretRequest{
+			cacheKey: "password:" + a.user + "@" + addr,
+			message:  fmt.Sprintf("%s@%s's password: ", a.user, addr),
+			remember: cred.RememberSecret,
+			save:     credentialSaver(cred, func(c *config.Credential, secret string) { c.Password = secret }),
+			hint:     "credential has no password, set password or password_command, or connect from a terminal",
+		})
+	}), maxPasswordAttempts)
+}
+
+// connectionPasswordMethod 返回未保存密码的连接在终端中输入密码的认证方法
+func (a *authConfig) connectionPasswordMethod(conn *config.Connection, addr string) ssh.AuthMethod {
+	var save func(string) error
+	if conn.Alias != "" {
+		save = func(secret string) error {
+			return config.RememberConnectionSecret(conn.Alias, func(c *config.Connection) { c.Password = secret })
+		}
+	}
+
+	return ssh.RetryableAuthMethod(ssh.PasswordCallback(func() (string, error) {
+		return a.promptSecret(secretRequest{
+			cacheKey: "password:" + a.user + "@" + addr,
+			message:  fmt.Sprintf("%s@%s's password: ", a.user, addr),
+			remember: conn.RememberSecret,
+			save:     save,
+			hint:     "no password available, connect from a terminal",
+		})
+	}), maxPasswordAttempts)
+}
+
+// credentialSaver 返回将密码写入保险库中凭证的函数，凭证没有别名时返回 nil
+func credentialSaver(cred *config.Credential, update func(c *config.Credential, secret string)) func(string) error {
+	if cred.Alias == "" {
+		return nil
+	}
+	return func(secret string) error {
+		return config.RememberCredentialSecret(cred.Alias, func(c *config.Credential) { update(c, secret) })
+	}
+}
+
+// credentialPassword 返回凭证的登录密码，未保存密码时执行密码命令获取
+func credentialPassword(cred *config.Credential) (string, error) {
+	if cred.Password != "" || cred.PasswordCommand == "" {
+		return cred.Password, nil
+	}
+	return runSecretCommand(cred.PasswordCommand)
+}
+
+// credentialKeyPassword 返回凭证的私钥密码，未保存密码时执行密码命令获取
+func credentialKeyPassword(cred *config.Credential) (string, error) {
+	if cred.KeyPassword != "" || cred.KeyPasswordCommand == "" {
+		return cred.KeyPassword, nil
+	}
+	return runSecretCommand(cred.KeyPasswordCommand)
+}
@@ -0,0 +1,100 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// 证书即将过期的提醒阈值
+const certificateExpiryWarning = 10 * time.Minute
+
+// LoadCertificate 读取 OpenSSH 用户证书（*-cert.pub）
+func LoadCertificate(path string) (*ssh.Certificate, error) {
+	data, err := os.ReadFile(os.ExpandEnv(path))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read certificate: %w", err)
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse certificate: %w", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an SSH certificate", path)
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("%s is not a user certificate", path)
+	}
+	return cert, nil
+}
+
+// certificateTime 将证书中的时间戳转换为 time.Time
+func certificateTime(t uint64) time.Time {
+	return time.Unix(int64(t), 0)
+}
+
+// CheckCertificateValidity 检查证书在给定时间是否处于有效期内
+func CheckCertificateValidity(cert *ssh.Certificate, now time.Time) error {
+	if cert.ValidAfter != 0 && now.Before(certificateTime(cert.ValidAfter)) {
+		return fmt.Errorf("certificate is not valid until %s", certificateTime(cert.ValidAfter).Format(time.RFC3339))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && !now.Before(certificateTime(cert.ValidBefore)) {
+		return fmt.Errorf("certificate expired at %s", certificateTime(cert.ValidBefore).Format(time.RFC3339))
+	}
+	return nil
+}
+
+// DescribeCertificate 返回证书的主体、有效期以及剩余时间
+func DescribeCertificate(cert *ssh.Certificate, now time.Time) string {
+	principals := "any"
+	if len(cert.ValidPrincipals) > 0 {
+		principals = strings.Join(cert.ValidPrincipals, ",")
+	}
+
+	from := "always"
+	if cert.ValidAfter != 0 {
+		from = certificateTime(cert.ValidAfter).Format("2006-01-02 15:04")
+	}
+
+	to, remaining := "forever", "never expires"
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		expiry := certificateTime(cert.ValidBefore)
+		to = expiry.Format("2006-01-02 15:04")
+		if now.Before(expiry) {
+			remaining = "expires in " + expiry.Sub(now).Round(time.Minute).String()
+		} else {
+			remaining = "EXPIRED"
+		}
+	}
+
+	return fmt.Sprintf("principals=%s valid %s -> %s (%s)", principals, from, to, remaining)
+}
+
+// certificateSigner 将私钥与证书组合为证书签名器，证书过期或尚未生效时返回错误
+func certificateSigner(certPath string, signer ssh.Signer) (ssh.Signer, error) {
+	cert, err := LoadCertificate(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := CheckCertificateValidity(cert, now); err != nil {
+		return nil, fmt.Errorf("%s: %w", certPath, err)
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		if remaining := certificateTime(cert.ValidBefore).Sub(now); remaining < certificateExpiryWarning {
+			fmt.Fprintf(os.Stderr, "Warning: certificate %s expires in %s\n", certPath, remaining.Round(time.Second))
+		}
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s does not match the private key: %w", certPath, err)
+	}
+	return certSigner, nil
+}
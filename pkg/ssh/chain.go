@@ -0,0 +1,38 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+	"golang.org/x/crypto/ssh"
+)
+
+// createChainedClient 按顺序使用连接的凭证链建立连接，直到某个凭证认证成功。
+// 主机密钥校验失败、代理或网络不可达时换用其它凭证也无济于事，因此直接返回
+func createChainedClient(conn *config.Connection, chain []string, pr prompt.Prompter) (*ssh.Client, error) {
+	var failures []string
+	for _, alias := range chain {
+		cred, err := config.GetCredential(alias)
+		if err == nil {
+			var client *ssh.Client
+			client, err = createSSHClient(conn, cred, pr)
+			if err == nil {
+				return client, nil
+			}
+
+			var hostKeyErr *hostKeyError
+			var dialErr *dialError
+			var netErr *net.OpError
+			if errors.As(err, &hostKeyErr) || errors.As(err, &dialErr) || errors.As(err, &netErr) {
+				return nil, err
+			}
+		}
+		failures = append(failures, fmt.Sprintf("  %s: %v", alias, err))
+	}
+
+	return nil, fmt.Errorf("all credentials failed for %s:\n%s", connectionAddr(conn), strings.Join(failures, "\n"))
+}
@@ -7,6 +7,7 @@ import (
 	"syscall"
 
 	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -16,19 +17,109 @@ func Connect(conn *config.Connection) error {
 	return ConnectWithCredential(conn, nil)
 }
 
-// Connect connects to an SSH server using the given configuration
-func ConnectWithCredential(conn *config.Connection, cred *config.Credential) error {
+// pooledSession 连接池客户端上的SSH会话，关闭时释放对连接的占用
+type pooledSession struct {
+	*ssh.Session
+	entry   *pooledClient
+	release func()
+}
+
+// Close 关闭会话并释放对连接的占用
+func (s *pooledSession) Close() error {
+	err := s.Session.Close()
+	s.release()
+	return err
+}
+
+// openSession 从连接池获取客户端并创建SSH会话，按配置请求 agent 转发
+func openSession(conn *config.Connection, cred *config.Credential) (*pooledSession, error) {
 	// 从连接池获取或创建SSH客户端
 	pool := GetConnectionPool()
-	client, err := pool.GetClient(conn, cred)
+	client, release, err := pool.AcquireClient(conn, cred)
 	if err != nil {
-		return fmt.Errorf("unable to establish SSH connection: %w", err)
+		return nil, fmt.Errorf("unable to establish SSH connection: %w", err)
 	}
 
 	// 创建SSH会话
 	session, err := client.NewSession()
 	if err != nil {
-		return fmt.Errorf("unable to create SSH session: %w", err)
+		release()
+		return nil, fmt.Errorf("unable to create SSH session: %w", err)
+	}
+	s := &pooledSession{Session: session, entry: pool.entryFor(client), release: release}
+
+	if conn.ForwardAgent {
+		if err := requestAgentForwarding(client, session, conn); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// lostError 会话异常结束后，底层连接已断开时返回 connection lost 错误，否则返回 nil
+func (s *pooledSession) lostError() error {
+	return GetConnectionPool().lostError(s.entry)
+}
+
+// VerifyLogin 不经过连接池，使用凭证（为 nil 时使用连接自身的认证方式）重新建立一次连接以确认可以登录
+func VerifyLogin(conn *config.Connection, cred *config.Credential) error {
+	var client *ssh.Client
+	var err error
+	if chain := conn.CredentialChain(); cred == nil && len(chain) > 0 {
+		client, err = createChainedClient(conn, chain, prompt.Current())
+	} else {
+		client, err = createSSHClient(conn, cred, prompt.Current())
+	}
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
+
+// VerifyKeyLogin 不经过连接池，只使用 key 类型凭证的私钥重新建立一次连接，确认服务器接受该密钥。
+// 不会退回到密码或 keyboard-interactive 认证，登录成功即说明密钥本身可用
+func VerifyKeyLogin(conn *config.Connection, cred *config.Credential) error {
+	pr := prompt.Current()
+	client, err := createClient(conn, pr, func() (*authConfig, error) {
+		return newKeyAuthConfig(conn, cred, pr)
+	})
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
+
+// ExecWithCredential 在远程服务器上执行命令，标准输入输出连接到本地终端
+func ExecWithCredential(conn *config.Connection, cred *config.Credential, command string) error {
+	session, err := openSession(conn, cred)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+	session.Stdin = os.Stdin
+
+	if err := session.Run(command); err != nil {
+		if e, ok := err.(*ssh.ExitError); ok {
+			return fmt.Errorf("command exited with code %d", e.ExitStatus())
+		}
+		if lost := session.lostError(); lost != nil {
+			return lost
+		}
+		return fmt.Errorf("failed to run command: %w", err)
+	}
+	return nil
+}
+
+// Connect connects to an SSH server using the given configuration
+func ConnectWithCredential(conn *config.Connection, cred *config.Credential) error {
+	session, err := openSession(conn, cred)
+	if err != nil {
+		return err
 	}
 	defer session.Close()
 
@@ -97,6 +188,9 @@ func ConnectWithCredential(conn *config.Connection, cred *config.Credential) err
 				return fmt.Errorf("command exited with code %d", e.ExitStatus())
 			}
 		}
+		if lost := session.lostError(); lost != nil {
+			return lost
+		}
 		return fmt.Errorf("session ended with error: %w", err)
 	}
 
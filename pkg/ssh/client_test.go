@@ -0,0 +1,52 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+)
+
+// writeKeyCredential 生成未加密的私钥，返回引用它的 key 凭证和生成的密钥
+func writeKeyCredential(t *testing.T) (*config.Credential, *GeneratedKey) {
+	t.Helper()
+	key, err := GenerateKey(KeyTypeEd25519, 0, "", "test")
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, key.PrivateKey, 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return &config.Credential{Alias: "new", Type: "key", KeyPath: path}, key
+}
+
+func TestVerifyKeyLoginDoesNotFallBackToPassword(t *testing.T) {
+	isolatePool(t)
+	srv := startTestSSHServer(t, 0)
+	conn := srv.connection(t, "root")
+	cred, key := writeKeyCredential(t)
+	// 模拟用户在终端中输入了服务器接受的密码
+	defer prompt.SetPrompter(&passwordPrompter{password: "pw"})()
+
+	// 普通的登录流程会退回到 keyboard-interactive，输入密码后登录成功
+	if err := VerifyLogin(conn, cred); err != nil {
+		t.Fatalf("login with password fallback: %v", err)
+	}
+
+	// 服务器尚未接受该密钥，只用密钥验证时必须失败
+	if err := VerifyKeyLogin(conn, cred); err == nil {
+		t.Fatal("key login verified although the server does not accept the key")
+	}
+
+	srv.acceptKey(key.Signer.PublicKey())
+	if err := VerifyKeyLogin(conn, cred); err != nil {
+		t.Errorf("key login with an accepted key: %v", err)
+	}
+
+	if err := VerifyKeyLogin(conn, &config.Credential{Alias: "pw", Type: "password", Password: "pw"}); err == nil {
+		t.Error("key login verified with a password credential")
+	}
+}
@@ -0,0 +1,523 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+	"golang.org/x/crypto/ssh"
+)
+
+// 守护进程与 sshm 进程之间使用 SSH 协议通信，以下为自定义的请求和通道类型
+const (
+	daemonAttachRequest = "attach@sshm"
+	daemonStatusRequest = "status@sshm"
+	daemonStopRequest   = "stop@sshm"
+	daemonLostRequest   = "lost@sshm"
+	daemonPromptChannel = "prompt@sshm"
+	// 查询和关闭连接池中的连接，关闭请求的内容为连接的键，为空时关闭全部连接
+	daemonPoolRequest      = "pool@sshm"
+	daemonPoolCloseRequest = "pool-close@sshm"
+
+	agentRequestType = "auth-agent-req@openssh.com"
+)
+
+// control_persist 的特殊值
+const (
+	ControlPersistYes = "yes"
+	ControlPersistNo  = "no"
+)
+
+// 守护进程转发心跳到上游连接时的超时时间
+const daemonKeepaliveTimeout = 5 * time.Second
+
+// 等待 sshm 进程应答转发的提示的最长时间，超时后放弃建立连接，等待同一连接的其他请求随之失败
+const daemonPromptTimeout = 5 * time.Minute
+
+// daemonMode 为 true 表示本进程是后台守护进程，连接池直接建立连接
+var daemonMode bool
+
+// attachRequest sshm 进程请求使用的连接，后续在该守护进程连接上打开的通道都转发到这个连接
+type attachRequest struct {
+	Connection  config.Connection
+	Credential  *config.Credential
+	Interactive bool
+}
+
+// DaemonStatus 描述运行中的后台守护进程
+type DaemonStatus struct {
+	PID         int       `json:"pid"`
+	Socket      string    `json:"socket"`
+	Started     time.Time `json:"started"`
+	Connections int       `json:"connections"`
+}
+
+// ValidateControlPersist 检查 control_persist 配置是否有效
+func ValidateControlPersist(value string) error {
+	switch value {
+	case "", ControlPersistYes, ControlPersistNo:
+		return nil
+	}
+	if d, err := time.ParseDuration(value); err != nil || d < 0 {
+		return fmt.Errorf("invalid control_persist value '%s': must be yes, no or a duration", value)
+	}
+	return nil
+}
+
+// Daemon 后台守护进程，在 unix socket 上为其他 sshm 进程提供连接池中的连接。
+// sshm 进程以 SSH 协议连接守护进程，选择连接后打开的会话和通道被转发到连接池中的上游连接
+type Daemon struct {
+	pool     *ConnectionPool
+	listener net.Listener
+	config   *ssh.ServerConfig
+	socket   string
+	started  time.Time
+
+	mu sync.Mutex
+	// 已连接的 sshm 进程及其选择的上游连接（尚未选择时为 nil）
+	sessions map[*ssh.ServerConn]*ssh.Client
+	// 已在监视关闭的上游连接
+	watched map[*ssh.Client]bool
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// ListenDaemon 在 sshm 配置目录下创建权限为 0600 的 unix socket，已有守护进程运行时返回错误
+func ListenDaemon() (*Daemon, error) {
+	socket := config.GetDaemonSocketPath()
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return nil, fmt.Errorf("error creating config directory: %w", err)
+	}
+	if status, err := QueryDaemon(); err == nil {
+		return nil, fmt.Errorf("sshm daemon is already running (pid %d)", status.PID)
+	}
+	// 清理上次异常退出留下的 socket 文件
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %w", socket, err)
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("unable to set socket permissions: %w", err)
+	}
+
+	// 每次启动生成新的主机密钥；只有本用户可以访问 socket，不需要客户端认证
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	// 守护进程自身不从终端读取输入，只有发起请求的 sshm 进程可以应答提示
+	daemonMode = true
+	prompt.SetPrompter(noPrompter{})
+
+	return &Daemon{
+		pool:     GetConnectionPool(),
+		listener: listener,
+		config:   serverConfig,
+		socket:   socket,
+		started:  time.Now(),
+		sessions: make(map[*ssh.ServerConn]*ssh.Client),
+		watched:  make(map[*ssh.Client]bool),
+		closed:   make(chan struct{}),
+	}, nil
+}
+
+// Serve 接受 sshm 进程的连接，直到守护进程关闭
+func (d *Daemon) Serve() error {
+	for {
+		netConn, err := d.listener.Accept()
+		if err != nil {
+			select {
+			case <-d.closed:
+				return nil
+			default:
+				return fmt.Errorf("unable to accept connection: %w", err)
+			}
+		}
+		go d.handle(netConn)
+	}
+}
+
+// Done 返回守护进程关闭后关闭的通道
+func (d *Daemon) Done() <-chan struct{} {
+	return d.closed
+}
+
+// Close 停止监听并关闭所有 sshm 进程的连接和连接池中的连接
+func (d *Daemon) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.closed)
+		d.listener.Close()
+
+		d.mu.Lock()
+		for sc := range d.sessions {
+			sc.Close()
+		}
+		d.mu.Unlock()
+		d.pool.Close()
+	})
+	return nil
+}
+
+// Status 返回守护进程的状态
+func (d *Daemon) Status() DaemonStatus {
+	return DaemonStatus{
+		PID:         os.Getpid(),
+		Socket:      d.socket,
+		Started:     d.started,
+		Connections: d.pool.size(),
+	}
+}
+
+// handle 处理一个 sshm 进程的连接
+func (d *Daemon) handle(netConn net.Conn) {
+	sc, chans, reqs, err := ssh.NewServerConn(netConn, d.config)
+	if err != nil {
+		debugf("daemon handshake failed: %v", err)
+		netConn.Close()
+		return
+	}
+	d.mu.Lock()
+	d.sessions[sc] = nil
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.sessions, sc)
+		d.mu.Unlock()
+	}()
+
+	go d.handleRequests(sc, reqs)
+	for nc := range chans {
+		upstream := d.upstream(sc)
+		if upstream == nil {
+			nc.Reject(ssh.Prohibited, "no connection attached")
+			continue
+		}
+		go d.proxyChannel(sc, upstream, nc)
+	}
+}
+
+// handleRequests 处理 sshm 进程发送的全局请求
+func (d *Daemon) handleRequests(sc *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	for r := range reqs {
+		switch r.Type {
+		case daemonAttachRequest:
+			if err := d.attach(sc, r.Payload); err != nil {
+				r.Reply(false, []byte(err.Error()))
+				continue
+			}
+			r.Reply(true, nil)
+		case "keepalive@openssh.com":
+			// 心跳转发到上游连接，使 sshm 进程能感知上游连接的状态
+			ok := true
+			if upstream := d.upstream(sc); upstream != nil {
+				ok = sendKeepalive(upstream, daemonKeepaliveTimeout) == nil
+			}
+			r.Reply(ok, nil)
+		case daemonStatusRequest:
+			data, err := json.Marshal(d.Status())
+			r.Reply(err == nil, data)
+		case daemonPoolRequest:
+			data, err := json.Marshal(d.pool.Snapshot())
+			r.Reply(err == nil, data)
+		case daemonPoolCloseRequest:
+			d.poolClose(r)
+		case daemonStopRequest:
+			log.Printf("stop requested")
+			r.Reply(true, nil)
+			go d.Close()
+		default:
+			if r.WantReply {
+				r.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// attach 为 sshm 进程获取或建立连接池中的连接
+func (d *Daemon) attach(sc *ssh.ServerConn, payload []byte) error {
+	var req attachRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return fmt.Errorf("invalid attach request: %w", err)
+	}
+	if d.upstream(sc) != nil {
+		return errors.New("a connection is already attached")
+	}
+	conn := req.Connection
+	if err := ValidateControlPersist(conn.ControlPersist); err != nil {
+		return err
+	}
+	if conn.ForwardAgent {
+		return errors.New("agent forwarding is not supported on shared daemon connections")
+	}
+	// control_persist 决定守护进程保留空闲连接的时间
+	switch conn.ControlPersist {
+	case "", ControlPersistNo:
+	case ControlPersistYes:
+		conn.IdleTimeout = "0"
+	default:
+		conn.IdleTimeout = conn.ControlPersist
+	}
+
+	// 建立连接需要的输入只转发给发起请求的 sshm 进程，不影响其他请求
+	var pr prompt.Prompter = noPrompter{}
+	if req.Interactive {
+		pr = &relayPrompter{conn: sc}
+	}
+
+	key := generateConnectionKey(&conn, req.Credential)
+	// sshm 进程连接期间保持占用，空闲时间从其断开后开始计算
+	upstream, release, err := d.pool.acquireClient(&conn, req.Credential, pr)
+	if err != nil {
+		log.Printf("%s: %v", key, err)
+		return err
+	}
+	log.Printf("%s: attached", key)
+	go func() {
+		_ = sc.Wait()
+		release()
+	}()
+
+	d.mu.Lock()
+	d.sessions[sc] = upstream
+	d.mu.Unlock()
+	d.watch(upstream)
+	return nil
+}
+
+// poolClose 关闭请求指定的连接，未指定时关闭全部连接，回复关闭的连接数
+func (d *Daemon) poolClose(r *ssh.Request) {
+	key := string(r.Payload)
+	if key == "" {
+		n := d.pool.EvictAll()
+		log.Printf("closed %d pooled connections on request", n)
+		r.Reply(true, []byte(strconv.Itoa(n)))
+		return
+	}
+	if err := d.pool.Evict(key); err != nil {
+		r.Reply(false, []byte(err.Error()))
+		return
+	}
+	log.Printf("%s: closing on request", key)
+	r.Reply(true, []byte("1"))
+}
+
+// upstream 返回 sshm 进程选择的上游连接
+func (d *Daemon) upstream(sc *ssh.ServerConn) *ssh.Client {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sessions[sc]
+}
+
+// watch 上游连接关闭时通知并断开使用它的 sshm 进程
+func (d *Daemon) watch(upstream *ssh.Client) {
+	entry := d.pool.entryFor(upstream)
+	d.mu.Lock()
+	if entry == nil || d.watched[upstream] {
+		d.mu.Unlock()
+		return
+	}
+	d.watched[upstream] = true
+	d.mu.Unlock()
+
+	go func() {
+		<-entry.done
+		reason := d.pool.closeReason(entry)
+		log.Printf("%s: closed: %v", entry.key, reason)
+
+		var affected []*ssh.ServerConn
+		d.mu.Lock()
+		for sc, client := range d.sessions {
+			if client == upstream {
+				affected = append(affected, sc)
+			}
+		}
+		delete(d.watched, upstream)
+		d.mu.Unlock()
+
+		for _, sc := range affected {
+			if reason != nil && !errors.Is(reason, io.EOF) {
+				sc.SendRequest(daemonLostRequest, false, []byte(reason.Error()))
+			}
+			sc.Close()
+		}
+	}()
+}
+
+// proxyChannel 在上游连接上打开同类型的通道，并与 sshm 进程打开的通道双向转发
+func (d *Daemon) proxyChannel(sc *ssh.ServerConn, upstream *ssh.Client, nc ssh.NewChannel) {
+	release := d.pool.AcquireChannel(upstream)
+	defer release()
+
+	// 上游连接由多个 sshm 进程共用，无法判断上游打开的 agent 通道属于哪个会话，因此不转发 agent
+	bridgeNewChannel(upstream, nc, func(r *ssh.Request) bool {
+		return r.Type != agentRequestType
+	})
+}
+
+// bridgeNewChannel 在 dst 上打开与 nc 同类型的通道，接受 nc 后双向转发数据和通道请求。
+// onRequest 在转发 nc 一侧的每个通道请求前调用，返回 false 时拒绝该请求
+func bridgeNewChannel(dst ssh.Conn, nc ssh.NewChannel, onRequest func(*ssh.Request) bool) {
+	remote, remoteReqs, err := dst.OpenChannel(nc.ChannelType(), nc.ExtraData())
+	if err != nil {
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			nc.Reject(openErr.Reason, openErr.Message)
+		} else {
+			nc.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	local, localReqs, err := nc.Accept()
+	if err != nil {
+		remote.Close()
+		return
+	}
+
+	var output sync.WaitGroup
+	output.Add(2)
+	go func() {
+		defer output.Done()
+		io.Copy(local, remote)
+		local.CloseWrite()
+	}()
+	go func() {
+		defer output.Done()
+		io.Copy(local.Stderr(), remote.Stderr())
+	}()
+	go func() {
+		io.Copy(remote, local)
+		remote.CloseWrite()
+	}()
+	go io.Copy(remote.Stderr(), local.Stderr())
+
+	go func() {
+		forwardChannelRequests(remote, localReqs, onRequest)
+		remote.Close()
+	}()
+	// 退出状态等请求在远端通道关闭前转发，输出转发完成后再关闭本地通道
+	forwardChannelRequests(local, remoteReqs, nil)
+	output.Wait()
+	local.Close()
+}
+
+// forwardChannelRequests 将通道请求转发到另一侧的通道，直到请求通道关闭
+func forwardChannelRequests(dst ssh.Channel, reqs <-chan *ssh.Request, onRequest func(*ssh.Request) bool) {
+	for r := range reqs {
+		if onRequest != nil && !onRequest(r) {
+			if r.WantReply {
+				r.Reply(false, nil)
+			}
+			continue
+		}
+		ok, err := dst.SendRequest(r.Type, r.WantReply, r.Payload)
+		if r.WantReply {
+			r.Reply(ok && err == nil, nil)
+		}
+	}
+}
+
+// promptRequest 守护进程转发给 sshm 进程的提示，Kind 为 "line"、"password" 或 "print"
+type promptRequest struct {
+	Kind    string
+	Message string
+}
+
+// promptReply sshm 进程对提示的应答
+type promptReply struct {
+	Answer string
+	Error  string
+}
+
+// relayPrompter 将提示转发到发起请求的 sshm 进程，由其在终端中输入
+type relayPrompter struct {
+	conn ssh.Conn
+}
+
+func (r *relayPrompter) IsInteractive() bool {
+	return true
+}
+
+func (r *relayPrompter) Line(message string) (string, error) {
+	return r.ask("line", message)
+}
+
+func (r *relayPrompter) Password(message string) (string, error) {
+	return r.ask("password", message)
+}
+
+func (r *relayPrompter) Print(message string) {
+	_, _ = r.ask("print", message)
+}
+
+// ask 为每个提示打开一个通道，发送提示并读取应答
+func (r *relayPrompter) ask(kind, message string) (string, error) {
+	payload, err := json.Marshal(promptRequest{Kind: kind, Message: message})
+	if err != nil {
+		return "", err
+	}
+	ch, reqs, err := r.conn.OpenChannel(daemonPromptChannel, payload)
+	if err != nil {
+		return "", fmt.Errorf("unable to forward prompt: %w", err)
+	}
+	go ssh.DiscardRequests(reqs)
+	defer ch.Close()
+
+	// 超时后关闭通道，使读取应答立即返回
+	timer := time.AfterFunc(daemonPromptTimeout, func() { ch.Close() })
+	var reply promptReply
+	err = json.NewDecoder(ch).Decode(&reply)
+	if !timer.Stop() {
+		return "", fmt.Errorf("no answer to prompt within %s", daemonPromptTimeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to read prompt answer: %w", err)
+	}
+	if reply.Error != "" {
+		return "", errors.New(reply.Error)
+	}
+	return reply.Answer, nil
+}
+
+// noPrompter 没有可用终端时使用
+type noPrompter struct{}
+
+func (noPrompter) IsInteractive() bool {
+	return false
+}
+
+func (noPrompter) Line(string) (string, error) {
+	return "", errors.New("no terminal available")
+}
+
+func (noPrompter) Password(string) (string, error) {
+	return "", errors.New("no terminal available")
+}
+
+func (noPrompter) Print(string) {}
@@ -0,0 +1,237 @@
+package ssh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+	"golang.org/x/crypto/ssh"
+)
+
+// 连接后台守护进程的超时时间
+const daemonDialTimeout = 2 * time.Second
+
+// ErrDaemonNotRunning 表示没有运行中的后台守护进程
+var ErrDaemonNotRunning = errors.New("sshm daemon is not running")
+
+// daemonLink 与后台守护进程的连接状态
+type daemonLink struct {
+	mu sync.Mutex
+	// 守护进程通知的上游连接断开原因
+	lost error
+	// 守护进程连接的全局请求处理结束后关闭
+	done chan struct{}
+}
+
+// handleRequests 记录守护进程发送的连接断开通知，其余请求交给 ssh.Client 处理
+func (l *daemonLink) handleRequests(in <-chan *ssh.Request, out chan<- *ssh.Request) {
+	defer close(l.done)
+	defer close(out)
+	for r := range in {
+		if r.Type == daemonLostRequest {
+			l.mu.Lock()
+			l.lost = errors.New(string(r.Payload))
+			l.mu.Unlock()
+			continue
+		}
+		out <- r
+	}
+}
+
+// lostReason 返回守护进程通知的上游连接断开原因
+func (l *daemonLink) lostReason() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// dialDaemon 连接后台守护进程，没有守护进程运行时返回 ErrDaemonNotRunning
+func dialDaemon() (*ssh.Client, *daemonLink, error) {
+	netConn, err := net.DialTimeout("unix", config.GetDaemonSocketPath(), daemonDialTimeout)
+	if err != nil {
+		return nil, nil, ErrDaemonNotRunning
+	}
+	// socket 位于只有本用户可以访问的配置目录中，不需要校验守护进程的主机密钥
+	meter := &meteredConn{Conn: netConn}
+	c, chans, reqs, err := ssh.NewClientConn(meter, "sshm-daemon", &ssh.ClientConfig{
+		User:            "sshm",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         daemonDialTimeout,
+	})
+	if err != nil {
+		netConn.Close()
+		return nil, nil, fmt.Errorf("unable to connect to sshm daemon: %w", err)
+	}
+
+	link := &daemonLink{done: make(chan struct{})}
+	forwarded := make(chan *ssh.Request)
+	go link.handleRequests(reqs, forwarded)
+	client := ssh.NewClient(c, chans, forwarded)
+	meterClient(client, meter)
+	return client, link, nil
+}
+
+// attachDaemon 通过后台守护进程获取连接。返回的客户端上打开的会话和通道由守护进程转发到连接池中的连接，
+// 需要输入的密码、验证码和主机密钥确认通过 pr 在本进程的终端中完成
+func attachDaemon(conn *config.Connection, cred *config.Credential, pr prompt.Prompter) (*ssh.Client, *daemonLink, error) {
+	client, link, err := dialDaemon()
+	if err != nil {
+		return nil, nil, err
+	}
+	if chans := client.HandleChannelOpen(daemonPromptChannel); chans != nil {
+		go servePrompts(chans, pr)
+	}
+
+	payload, err := json.Marshal(attachRequest{
+		Connection:  *conn,
+		Credential:  cred,
+		Interactive: pr.IsInteractive(),
+	})
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	ok, reply, err := client.SendRequest(daemonAttachRequest, true, payload)
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("unable to attach to sshm daemon: %w", err)
+	}
+	if !ok {
+		client.Close()
+		return nil, nil, daemonRefusal(reply)
+	}
+	debugf("using sshm daemon for %s", connectionAddr(conn))
+	return client, link, nil
+}
+
+// servePrompts 通过 pr 应答守护进程转发的提示，按顺序逐个处理
+func servePrompts(chans <-chan ssh.NewChannel, pr prompt.Prompter) {
+	for nc := range chans {
+		var req promptRequest
+		if err := json.Unmarshal(nc.ExtraData(), &req); err != nil {
+			nc.Reject(ssh.ConnectionFailed, "invalid prompt request")
+			continue
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go ssh.DiscardRequests(reqs)
+
+		var reply promptReply
+		switch req.Kind {
+		case "line":
+			reply.Answer, err = pr.Line(req.Message)
+		case "password":
+			reply.Answer, err = pr.Password(req.Message)
+		case "print":
+			pr.Print(req.Message)
+		default:
+			err = fmt.Errorf("unknown prompt kind '%s'", req.Kind)
+		}
+		if err != nil {
+			reply.Error = err.Error()
+		}
+		_ = json.NewEncoder(ch).Encode(reply)
+		ch.Close()
+	}
+}
+
+// QueryDaemon 返回运行中的后台守护进程的状态，没有守护进程运行时返回 ErrDaemonNotRunning
+func QueryDaemon() (*DaemonStatus, error) {
+	client, _, err := dialDaemon()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	ok, reply, err := client.SendRequest(daemonStatusRequest, true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query sshm daemon status: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unable to query sshm daemon status: %w", daemonRefusal(reply))
+	}
+	var status DaemonStatus
+	if err := json.Unmarshal(reply, &status); err != nil {
+		return nil, fmt.Errorf("invalid sshm daemon status: %w", err)
+	}
+	return &status, nil
+}
+
+// StopDaemon 请求后台守护进程关闭所有连接并退出，等待其断开连接后返回
+func StopDaemon() error {
+	client, _, err := dialDaemon()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ok, reply, err := client.SendRequest(daemonStopRequest, true, nil)
+	if err != nil {
+		return fmt.Errorf("unable to stop sshm daemon: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("unable to stop sshm daemon: %w", daemonRefusal(reply))
+	}
+	_ = client.Wait()
+	return nil
+}
+
+// QueryDaemonPool 返回后台守护进程连接池中所有连接的状态，没有守护进程运行时返回 ErrDaemonNotRunning
+func QueryDaemonPool() ([]PoolEntryStats, error) {
+	client, _, err := dialDaemon()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	ok, reply, err := client.SendRequest(daemonPoolRequest, true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query sshm daemon pool: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unable to query sshm daemon pool: %w", daemonRefusal(reply))
+	}
+	var stats []PoolEntryStats
+	if err := json.Unmarshal(reply, &stats); err != nil {
+		return nil, fmt.Errorf("invalid sshm daemon pool status: %w", err)
+	}
+	return stats, nil
+}
+
+// CloseDaemonPool 关闭后台守护进程连接池中键为 key 的连接，key 为空时关闭全部连接，返回关闭的连接数
+func CloseDaemonPool(key string) (int, error) {
+	client, _, err := dialDaemon()
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	ok, reply, err := client.SendRequest(daemonPoolCloseRequest, true, []byte(key))
+	if err != nil {
+		return 0, fmt.Errorf("unable to close pooled connections: %w", err)
+	}
+	if !ok {
+		return 0, daemonRefusal(reply)
+	}
+	n, err := strconv.Atoi(string(reply))
+	if err != nil {
+		return 0, fmt.Errorf("invalid sshm daemon reply: %w", err)
+	}
+	return n, nil
+}
+
+// daemonRefusal 返回守护进程拒绝请求的原因，守护进程未说明原因时返回固定的错误
+func daemonRefusal(reply []byte) error {
+	if len(reply) == 0 {
+		return errors.New("request refused by sshm daemon")
+	}
+	return errors.New(string(reply))
+}
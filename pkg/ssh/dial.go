@@ -0,0 +1,115 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+	"golang.org/x/crypto/ssh"
+)
+
+// 默认连接超时
+const defaultConnectTimeout = 10 * time.Second
+
+// connectTimeout 返回连接配置的超时时间
+func connectTimeout(conn *config.Connection) (time.Duration, error) {
+	if conn.Timeout == "" {
+		return defaultConnectTimeout, nil
+	}
+	timeout, err := time.ParseDuration(conn.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout value: %w", err)
+	}
+	return timeout, nil
+}
+
+// dialTransport 建立到目标地址的底层连接，根据配置选择跳板机、代理命令、代理或直接连接。
+// user 为登录用户名，用于替换代理命令中的 %r；pr 用于建立跳板连接时的输入
+func dialTransport(conn *config.Connection, addr, user string, timeout time.Duration, pr prompt.Prompter) (net.Conn, error) {
+	// 配置了跳板机时代理只作用于第一跳
+	if len(conn.Jump) > 0 {
+		return dialViaJump(conn, addr, timeout, pr)
+	}
+	if conn.ProxyCommand != "" {
+		return dialProxyCommand(conn, addr, user)
+	}
+
+	proxyURL, err := resolveProxy(conn)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		// 直接连接（不使用代理）
+		netConn, err := net.DialTimeout(tcpNetwork(conn), addr, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to SSH server: %w", err)
+		}
+		return netConn, nil
+	}
+	return dialProxy(conn, proxyURL, addr, timeout)
+}
+
+// 连接目标时的地址族
+const (
+	AddressFamilyAny   = "any"
+	AddressFamilyInet  = "inet"
+	AddressFamilyInet6 = "inet6"
+)
+
+// ValidateAddressFamily 检查地址族配置是否有效
+func ValidateAddressFamily(family string) error {
+	switch family {
+	case "", AddressFamilyAny, AddressFamilyInet, AddressFamilyInet6:
+		return nil
+	default:
+		return fmt.Errorf("invalid address_family '%s': must be inet, inet6 or any", family)
+	}
+}
+
+// tcpNetwork 返回按地址族直接拨号时使用的网络类型
+func tcpNetwork(conn *config.Connection) string {
+	switch conn.AddressFamily {
+	case AddressFamilyInet:
+		return "tcp4"
+	case AddressFamilyInet6:
+		return "tcp6"
+	default:
+		return "tcp"
+	}
+}
+
+// ipNetwork 返回按地址族在本地解析主机名时使用的网络类型
+func ipNetwork(conn *config.Connection) string {
+	switch conn.AddressFamily {
+	case AddressFamilyInet:
+		return "ip4"
+	case AddressFamilyInet6:
+		return "ip6"
+	default:
+		return "ip"
+	}
+}
+
+// dialError 标记底层连接（直连、代理或跳板机）建立失败，凭证链遇到此错误时不再尝试其它凭证
+type dialError struct {
+	err error
+}
+
+func (e *dialError) Error() string { return e.err.Error() }
+func (e *dialError) Unwrap() error { return e.err }
+
+// newClientConn 在已建立的底层连接上完成SSH握手并创建客户端
+func newClientConn(netConn net.Conn, addr string, clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	meter := &meteredConn{Conn: netConn}
+	conn, chans, reqs, err := ssh.NewClientConn(meter, addr, clientConfig)
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("unable to create SSH client connection: %w", err)
+	}
+
+	client := ssh.NewClient(conn, chans, reqs)
+	meterClient(client, meter)
+	return client, nil
+}
@@ -0,0 +1,226 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+	"golang.org/x/crypto/ssh"
+)
+
+// 多地址的选择方式
+const (
+	HostSelectionOrdered = "ordered"
+	HostSelectionRace    = "race"
+)
+
+const (
+	// race 模式下启动下一个地址连接前的等待时间（参考 Happy Eyeballs）
+	raceDelay = 250 * time.Millisecond
+	// 重试等待时间的默认初始值和上限
+	defaultRetryBackoff = time.Second
+	maxRetryBackoff     = 30 * time.Second
+)
+
+// ValidateFailover 检查多地址和重试配置是否有效
+func ValidateFailover(conn *config.Connection) error {
+	switch conn.HostSelection {
+	case "", HostSelectionOrdered, HostSelectionRace:
+	default:
+		return fmt.Errorf("invalid host_selection '%s': must be ordered or race", conn.HostSelection)
+	}
+	if conn.Retries < 0 {
+		return fmt.Errorf("invalid retries %d: must not be negative", conn.Retries)
+	}
+	if _, err := retryBackoff(conn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// retryBackoff 返回首次重试前的等待时间
+func retryBackoff(conn *config.Connection) (time.Duration, error) {
+	if conn.RetryBackoff == "" {
+		return defaultRetryBackoff, nil
+	}
+	backoff, err := time.ParseDuration(conn.RetryBackoff)
+	if err != nil || backoff < 0 {
+		return 0, fmt.Errorf("invalid retry_backoff value '%s'", conn.RetryBackoff)
+	}
+	return backoff, nil
+}
+
+// dialTarget 按连接的地址列表和重试策略建立底层连接，返回连接及其使用的地址。
+// 经由跳板机时，建立跳板连接需要的输入通过 pr 获取
+func dialTarget(conn *config.Connection, user string, timeout time.Duration, pr prompt.Prompter) (net.Conn, string, error) {
+	backoff, err := retryBackoff(conn)
+	if err != nil {
+		return nil, "", err
+	}
+	addrs := orderAddresses(conn)
+
+	for attempt := 0; ; attempt++ {
+		netConn, addr, err := dialAddresses(conn, addrs, user, timeout, pr)
+		if err == nil {
+			return netConn, addr, nil
+		}
+		if attempt >= conn.Retries || !isRetryable(err) {
+			return nil, "", err
+		}
+
+		fmt.Fprintf(os.Stderr, "Connection to %s failed, retrying in %s (%d/%d)\n", conn.Host, backoff, attempt+1, conn.Retries)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+// isRetryable 判断连接失败是否可能在重试后成功。只有网络错误（连接被拒绝、超时、DNS 解析失败等）
+// 和跳板机无法连接目标时重试，配置、代理认证等错误重试也不会成功，直接返回
+func isRetryable(err error) bool {
+	var opErr *net.OpError
+	var netErr net.Error
+	var chanErr *ssh.OpenChannelError
+	switch {
+	case errors.As(err, &opErr):
+		return true
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	case errors.As(err, &chanErr) && chanErr.Reason == ssh.ConnectionFailed:
+		return true
+	}
+	return false
+}
+
+// rememberAddress 记录 SSH 连接成功的地址，下次优先尝试
+func rememberAddress(conn *config.Connection, addr string) {
+	if len(conn.Hosts) < 2 || conn.Alias == "" {
+		return
+	}
+	if err := config.RememberAddress(conn.Alias, addr); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to remember address for '%s': %v\n", conn.Alias, err)
+	}
+}
+
+// orderAddresses 返回连接的地址列表，上次连接成功的地址排在最前
+func orderAddresses(conn *config.Connection) []string {
+	addrs := conn.Addresses()
+	if len(addrs) < 2 || conn.Alias == "" {
+		return addrs
+	}
+	last := config.LastAddress(conn.Alias)
+	if i := slices.Index(addrs, last); i > 0 {
+		addrs = append([]string{last}, slices.Delete(addrs, i, i+1)...)
+	}
+	return addrs
+}
+
+// dialAddresses 按选择方式连接地址列表，返回第一个成功的连接及其地址
+func dialAddresses(conn *config.Connection, addrs []string, user string, timeout time.Duration, pr prompt.Prompter) (net.Conn, string, error) {
+	if len(addrs) > 1 && conn.HostSelection == HostSelectionRace {
+		return raceAddresses(conn, addrs, user, timeout, pr)
+	}
+
+	var failures []error
+	for _, addr := range addrs {
+		debugf("trying %s", addr)
+		netConn, err := dialTransport(conn, addr, user, timeout, pr)
+		if err == nil {
+			debugf("connected to %s", addr)
+			return netConn, addr, nil
+		}
+		debugf("%s failed: %v", addr, err)
+		if len(addrs) == 1 {
+			return nil, "", err
+		}
+		failures = append(failures, &addressError{addr: addr, err: err})
+	}
+	return nil, "", &addressesError{host: conn.Host, failures: failures}
+}
+
+// raceAddresses 交错启动各地址的连接：前一个地址在 raceDelay 内没有结果或已失败时启动下一个，
+// 最先成功的连接胜出，其余迟到的连接被关闭
+func raceAddresses(conn *config.Connection, addrs []string, user string, timeout time.Duration, pr prompt.Prompter) (net.Conn, string, error) {
+	type result struct {
+		conn net.Conn
+		addr string
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	next, pending := 0, 0
+	launch := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		debugf("trying %s", addr)
+		go func() {
+			netConn, err := dialTransport(conn, addr, user, timeout, pr)
+			results <- result{netConn, addr, err}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(raceDelay)
+	defer timer.Stop()
+
+	var failures []error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				debugf("connected to %s", r.addr)
+				go func(remaining int) {
+					for ; remaining > 0; remaining-- {
+						if late := <-results; late.err == nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, r.addr, nil
+			}
+			debugf("%s failed: %v", r.addr, r.err)
+			failures = append(failures, &addressError{addr: r.addr, err: r.err})
+			if next < len(addrs) {
+				launch()
+				timer.Reset(raceDelay)
+			}
+		case <-timer.C:
+			if next < len(addrs) {
+				launch()
+				timer.Reset(raceDelay)
+			}
+		}
+	}
+	return nil, "", &addressesError{host: conn.Host, failures: failures}
+}
+
+// addressError 一个地址的连接失败原因
+type addressError struct {
+	addr string
+	err  error
+}
+
+func (e *addressError) Error() string { return fmt.Sprintf("  %s: %v", e.addr, e.err) }
+func (e *addressError) Unwrap() error { return e.err }
+
+// addressesError 汇总所有地址的连接失败原因，任一地址的错误可以重试时整体重试
+type addressesError struct {
+	host     string
+	failures []error
+}
+
+func (e *addressesError) Error() string {
+	lines := make([]string, len(e.failures))
+	for i, failure := range e.failures {
+		lines[i] = failure.Error()
+	}
+	return fmt.Sprintf("unable to connect to any address of %s:\n%s", e.host, strings.Join(lines, "\n"))
+}
+
+func (e *addressesError) Unwrap() []error { return e.failures }
@@ -0,0 +1,329 @@
+package ssh
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// 主机密钥校验策略
+const (
+	HostKeyCheckingYes       = "yes"        // 只接受已知主机密钥
+	HostKeyCheckingAsk       = "ask"        // 未知主机时询问用户（默认）
+	HostKeyCheckingAcceptNew = "accept-new" // 自动记录新主机，拒绝变更的密钥
+	HostKeyCheckingNo        = "no"         // 不校验主机密钥
+)
+
+// ValidateHostKeyChecking 校验主机密钥策略取值
+func ValidateHostKeyChecking(mode string) error {
+	switch mode {
+	case "", HostKeyCheckingYes, HostKeyCheckingAsk, HostKeyCheckingAcceptNew, HostKeyCheckingNo:
+		return nil
+	default:
+		return fmt.Errorf("invalid strict host key checking value '%s': must be yes, ask, accept-new or no", mode)
+	}
+}
+
+// hostKeyCheckingMode 返回连接生效的主机密钥校验策略
+func hostKeyCheckingMode(conn *config.Connection) string {
+	if conn.StrictHostKeyChecking == "" {
+		return HostKeyCheckingAsk
+	}
+	return conn.StrictHostKeyChecking
+}
+
+// knownHostsFiles 返回需要读取的 known_hosts 文件列表（仅包含已存在的文件）
+func knownHostsFiles(conn *config.Connection) []string {
+	candidates := []string{config.GetKnownHostsPath()}
+	if conn.UseSystemKnownHosts {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			candidates = append(candidates, filepath.Join(homeDir, ".ssh", "known_hosts"))
+		}
+	}
+
+	var files []string
+	for _, file := range candidates {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// newHostKeyCallback 根据连接的校验策略创建主机密钥回调，未知主机通过 pr 询问是否信任
+func newHostKeyCallback(conn *config.Connection, pr prompt.Prompter) (ssh.HostKeyCallback, error) {
+	// 固定指纹优先于 known_hosts，且不受校验策略影响
+	if conn.HostKeyFingerprint != "" {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			fingerprint := ssh.FingerprintSHA256(key)
+			if fingerprint != conn.HostKeyFingerprint || (conn.HostKeyType != "" && key.Type() != conn.HostKeyType) {
+				return fmt.Errorf("host key for %s does not match the pinned key: server presented %s %s, pinned %s %s",
+					hostname, key.Type(), fingerprint, conn.HostKeyType, conn.HostKeyFingerprint)
+			}
+			return nil
+		}, nil
+	}
+
+	mode := hostKeyCheckingMode(conn)
+	if err := ValidateHostKeyChecking(mode); err != nil {
+		return nil, err
+	}
+
+	check, err := knownhosts.New(knownHostsFiles(conn)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read known_hosts: %w", err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return fmt.Errorf("host key verification failed for %s: %w", hostname, err)
+		}
+
+		fingerprint := ssh.FingerprintSHA256(key)
+
+		// 已知主机但密钥不一致：可能遭受中间人攻击
+		if len(keyErr.Want) > 0 {
+			known := keyErr.Want[0]
+			if mode == HostKeyCheckingNo {
+				fmt.Fprintf(os.Stderr, "Warning: host key for %s has changed (%s %s), continuing because strict host key checking is disabled\n",
+					hostname, key.Type(), fingerprint)
+				return nil
+			}
+			return fmt.Errorf("host key mismatch for %s: server presented %s %s, but %s:%d records %s %s; "+
+				"this could be a man-in-the-middle attack, remove the old entry only if the key was rotated legitimately",
+				hostname, key.Type(), fingerprint, known.Filename, known.Line,
+				known.Key.Type(), ssh.FingerprintSHA256(known.Key))
+		}
+
+		// 未知主机
+		switch mode {
+		case HostKeyCheckingNo:
+			return nil
+		case HostKeyCheckingAcceptNew:
+			return addKnownHost(hostname, key)
+		case HostKeyCheckingYes:
+			return fmt.Errorf("no host key is known for %s (%s %s) and strict host key checking is enabled",
+				hostname, key.Type(), fingerprint)
+		default:
+			if !pr.IsInteractive() {
+				return fmt.Errorf("no host key is known for %s (%s %s); connect interactively once or set strict_host_key_checking to accept-new",
+					hostname, key.Type(), fingerprint)
+			}
+			pr.Print(fmt.Sprintf("The authenticity of host '%s' can't be established.\n", hostname))
+			pr.Print(fmt.Sprintf("%s key fingerprint is %s.\n", key.Type(), fingerprint))
+			ok, err := prompt.ConfirmWith(pr, "Are you sure you want to continue connecting")
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("host key verification failed for %s", hostname)
+			}
+			return addKnownHost(hostname, key)
+		}
+	}, nil
+}
+
+// addKnownHost 将主机密钥追加到 sshm 的 known_hosts 文件
+func addKnownHost(hostname string, key ssh.PublicKey) error {
+	path := config.GetKnownHostsPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("error creating config directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening known_hosts: %w", err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		return fmt.Errorf("error writing known_hosts: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Permanently added '%s' (%s) to the list of known hosts.\n", hostname, key.Type())
+	return nil
+}
+
+// probeKey 是一个不会与任何真实密钥相等的公钥，用于查询 known_hosts 中已记录的密钥
+type probeKey struct{}
+
+func (probeKey) Type() string                                 { return "sshm-probe" }
+func (probeKey) Marshal() []byte                              { return []byte("sshm-probe") }
+func (probeKey) Verify(data []byte, sig *ssh.Signature) error { return errors.New("probe key") }
+
+// KnownHostKeys 返回 known_hosts 中为该连接记录的主机密钥
+func KnownHostKeys(conn *config.Connection) ([]knownhosts.KnownKey, error) {
+	check, err := knownhosts.New(knownHostsFiles(conn)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read known_hosts: %w", err)
+	}
+
+	var keyErr *knownhosts.KeyError
+	if err := check(connectionAddr(conn), &net.TCPAddr{}, probeKey{}); !errors.As(err, &keyErr) {
+		return nil, err
+	}
+	return keyErr.Want, nil
+}
+
+// ForgetKnownHost 从 sshm 的 known_hosts 文件中删除该连接的所有记录，返回删除的行数
+func ForgetKnownHost(conn *config.Connection) (int, error) {
+	path := config.GetKnownHostsPath()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading known_hosts: %w", err)
+	}
+
+	host := knownhosts.Normalize(connectionAddr(conn))
+	var kept []string
+	removed := 0
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && !strings.HasPrefix(fields[0], "#") && slices.Contains(strings.Split(fields[0], ","), host) {
+			removed++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	content := strings.Join(kept, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return 0, fmt.Errorf("error writing known_hosts: %w", err)
+	}
+	return removed, nil
+}
+
+// errHostKeyScanned 在获取到主机密钥后中止握手
+var errHostKeyScanned = errors.New("host key scanned")
+
+// ScanHostKey 通过与正常连接相同的代理逻辑完成握手，返回服务器提供的主机密钥
+func ScanHostKey(conn *config.Connection) (ssh.PublicKey, error) {
+	timeout, err := connectTimeout(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := connectionAddr(conn)
+	netConn, _, err := dialTarget(conn, conn.User, timeout, prompt.Current())
+	if err != nil {
+		return nil, err
+	}
+	defer netConn.Close()
+
+	var hostKey ssh.PublicKey
+	clientConfig := &ssh.ClientConfig{
+		User: "sshm-scan",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyScanned
+		},
+		HostKeyAlgorithms: hostKeyAlgorithms(conn, addr),
+		Timeout:           timeout,
+	}
+
+	_, _, _, err = ssh.NewClientConn(netConn, addr, clientConfig)
+	if hostKey == nil {
+		return nil, fmt.Errorf("unable to scan host key of %s: %w", addr, err)
+	}
+	return hostKey, nil
+}
+
+// ValidateFingerprint 校验 SHA256 指纹格式
+func ValidateFingerprint(fingerprint string) error {
+	encoded, ok := strings.CutPrefix(fingerprint, "SHA256:")
+	if !ok {
+		return fmt.Errorf("invalid fingerprint '%s': must start with SHA256:", fingerprint)
+	}
+	if raw, err := base64.RawStdEncoding.DecodeString(encoded); err != nil || len(raw) != sha256.Size {
+		return fmt.Errorf("invalid fingerprint '%s': not a base64 encoded SHA256 digest", fingerprint)
+	}
+	return nil
+}
+
+// connectionAddr 返回连接的 host:port 地址，用于主机密钥校验；配置了多个地址时仍使用 Host
+func connectionAddr(conn *config.Connection) string {
+	return net.JoinHostPort(conn.Host, strconv.Itoa(conn.Port))
+}
+
+// hostKeyAlgorithms 返回握手时优先协商的主机密钥算法：
+// 已固定密钥类型时只接受该类型，否则优先使用 known_hosts 中已记录的类型
+func hostKeyAlgorithms(conn *config.Connection, hostname string) []string {
+	if conn.HostKeyType != "" {
+		return expandHostKeyAlgorithms([]string{conn.HostKeyType})
+	}
+	return knownHostKeyAlgorithms(conn, hostname)
+}
+
+// expandHostKeyAlgorithms 将密钥类型展开为对应的签名算法
+func expandHostKeyAlgorithms(keyTypes []string) []string {
+	var algorithms []string
+	seen := make(map[string]bool)
+	for _, keyType := range keyTypes {
+		candidates := []string{keyType}
+		if keyType == ssh.KeyAlgoRSA {
+			candidates = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+		}
+		for _, algo := range candidates {
+			if !seen[algo] {
+				seen[algo] = true
+				algorithms = append(algorithms, algo)
+			}
+		}
+	}
+	return algorithms
+}
+
+// knownHostKeyAlgorithms 返回 known_hosts 中该主机已记录密钥对应的算法，
+// 优先协商这些算法可以避免服务器提供另一种类型的密钥时被误判为密钥变更
+func knownHostKeyAlgorithms(conn *config.Connection, hostname string) []string {
+	check, err := knownhosts.New(knownHostsFiles(conn)...)
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if err := check(hostname, &net.TCPAddr{}, probeKey{}); !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var keyTypes []string
+	for _, known := range keyErr.Want {
+		keyTypes = append(keyTypes, known.Key.Type())
+	}
+	return expandHostKeyAlgorithms(keyTypes)
+}
+
+// hostKeyError 标记主机密钥校验失败，凭证链遇到此错误时不再尝试其它凭证
+type hostKeyError struct {
+	err error
+}
+
+func (e *hostKeyError) Error() string { return e.err.Error() }
+func (e *hostKeyError) Unwrap() error { return e.err }
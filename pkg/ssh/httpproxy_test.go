@@ -0,0 +1,230 @@
+package ssh
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/config"
+)
+
+// 测试中通过代理连接的目标地址，代理替身不会真正连接它
+const proxyTestTarget = "203.0.113.7:22"
+
+// startConnectProxy 启动一个处理单个 CONNECT 请求的代理替身，返回其地址。
+// tlsConfig 不为 nil 时以 HTTPS 代理方式监听
+func startConnectProxy(t *testing.T, tlsConfig *tls.Config, handle func(c net.Conn, req *http.Request)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		req, err := http.ReadRequest(bufio.NewReader(c))
+		if err != nil {
+			return
+		}
+		handle(c, req)
+	}()
+	return ln.Addr().String()
+}
+
+// dialTestProxy 通过 proxyURL 指定的代理连接测试目标
+func dialTestProxy(t *testing.T, proxyURL, caFile string) (net.Conn, error) {
+	t.Helper()
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		t.Fatalf("parse proxy URL: %v", err)
+	}
+	conn := &config.Connection{Proxy: proxyURL, ProxyCAFile: caFile}
+	return dialProxy(conn, u, proxyTestTarget, 5*time.Second)
+}
+
+func TestHTTPConnectEstablishesTunnel(t *testing.T) {
+	addr := startConnectProxy(t, nil, func(c net.Conn, req *http.Request) {
+		if req.Method != http.MethodConnect || req.Host != proxyTestTarget {
+			io.WriteString(c, "HTTP/1.1 400 Bad Request\r\n\r\n")
+			return
+		}
+		if req.Header.Get("Proxy-Authorization") != "" {
+			io.WriteString(c, "HTTP/1.1 400 Bad Request\r\n\r\n")
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+		// 回显隧道中的数据
+		io.Copy(c, c)
+	})
+
+	tunnel, err := dialTestProxy(t, "http://"+addr, "")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer tunnel.Close()
+	if _, ok := tunnel.(*bufferedConn); ok {
+		t.Errorf("tunnel should not be buffered when the proxy sent nothing after the headers")
+	}
+
+	if _, err := io.WriteString(tunnel, "ping"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(tunnel, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("tunnel echoed %q, want %q", buf, "ping")
+	}
+}
+
+func TestHTTPConnectProxyAuthRequired(t *testing.T) {
+	tests := []struct {
+		name     string
+		userInfo string
+		want     []string
+	}{
+		{
+			name: "without credentials",
+			want: []string{"proxy authentication required (407)", `proxy requested: Basic realm="test"`, "add credentials to the proxy URL"},
+		},
+		{
+			name:     "with credentials",
+			userInfo: "alice:s3cret@",
+			want:     []string{"proxy rejected the credentials in the proxy URL (407)", `proxy requested: Basic realm="test"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotAuth := make(chan string, 1)
+			addr := startConnectProxy(t, nil, func(c net.Conn, req *http.Request) {
+				gotAuth <- req.Header.Get("Proxy-Authorization")
+				io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n"+
+					"Proxy-Authenticate: Basic realm=\"test\"\r\nContent-Length: 0\r\n\r\n")
+			})
+
+			_, err := dialTestProxy(t, "http://"+tt.userInfo+addr, "")
+			if err == nil {
+				t.Fatal("dial succeeded, want 407 error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+
+			wantAuth := ""
+			if tt.userInfo != "" {
+				wantAuth = "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:s3cret"))
+			}
+			if auth := <-gotAuth; auth != wantAuth {
+				t.Errorf("Proxy-Authorization = %q, want %q", auth, wantAuth)
+			}
+		})
+	}
+}
+
+func TestHTTPConnectRefused(t *testing.T) {
+	addr := startConnectProxy(t, nil, func(c net.Conn, req *http.Request) {
+		io.WriteString(c, "HTTP/1.1 403 Forbidden\r\nContent-Length: 15\r\n\r\nport 22 blocked")
+	})
+
+	_, err := dialTestProxy(t, "http://"+addr, "")
+	if err == nil {
+		t.Fatal("dial succeeded, want 403 error")
+	}
+	want := "proxy refused CONNECT to " + proxyTestTarget + ": 403 Forbidden: port 22 blocked"
+	if err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
+
+func TestHTTPConnectKeepsDataBufferedAfterHeaders(t *testing.T) {
+	addr := startConnectProxy(t, nil, func(c net.Conn, req *http.Request) {
+		// 响应头和隧道中的第一段数据一起发送，客户端读取响应时会一并读入缓冲区
+		io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\nSSH-2.0-stand-in\r\n")
+		io.Copy(io.Discard, c)
+	})
+
+	tunnel, err := dialTestProxy(t, "http://"+addr, "")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer tunnel.Close()
+	if _, ok := tunnel.(*bufferedConn); !ok {
+		t.Fatalf("tunnel is %T, want *bufferedConn", tunnel)
+	}
+
+	line, err := bufio.NewReader(tunnel).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if line != "SSH-2.0-stand-in\r\n" {
+		t.Errorf("read %q from tunnel, want the server banner", line)
+	}
+}
+
+func TestHTTPSConnectWithProxyCAFile(t *testing.T) {
+	// 借用 httptest 的自签名证书（包含 127.0.0.1），写入 CA 文件供 proxy_ca_file 使用
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	tlsConfig := &tls.Config{Certificates: srv.TLS.Certificates}
+	caFile := filepath.Join(t.TempDir(), "proxy-ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatalf("write CA file: %v", err)
+	}
+
+	t.Run("trusted", func(t *testing.T) {
+		addr := startConnectProxy(t, tlsConfig, func(c net.Conn, req *http.Request) {
+			io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+			io.Copy(c, c)
+		})
+
+		tunnel, err := dialTestProxy(t, "https://"+addr, caFile)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer tunnel.Close()
+		if _, ok := tunnel.(*tls.Conn); !ok {
+			t.Errorf("tunnel is %T, want *tls.Conn", tunnel)
+		}
+
+		io.WriteString(tunnel, "ping")
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(tunnel, buf); err != nil || string(buf) != "ping" {
+			t.Errorf("tunnel echoed %q (%v), want %q", buf, err, "ping")
+		}
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		addr := startConnectProxy(t, tlsConfig, func(c net.Conn, req *http.Request) {
+			io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+		})
+
+		_, err := dialTestProxy(t, "https://"+addr, "")
+		if err == nil || !strings.Contains(err.Error(), "TLS handshake with HTTPS proxy") {
+			t.Errorf("error = %v, want a TLS handshake failure without the proxy CA", err)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+)
+
+// lastJumpHop 返回跳板链中直接连接目标的最后一跳。
+// 对于 jump: [h1, h2, ..., hn]，hn 经由 [h1, ..., hn-1] 连接，h1 使用自身的配置，
+// 因此多个位于同一跳板机之后的目标共享连接池中的同一个跳板连接
+func lastJumpHop(conn *config.Connection) (*config.Connection, error) {
+	hops := conn.Jump
+	hop, err := config.GetConnection(hops[len(hops)-1])
+	if err != nil {
+		return nil, fmt.Errorf("jump host '%s': %w", hops[len(hops)-1], err)
+	}
+	if len(hops) > 1 {
+		hop.Jump = slices.Clone(hops[:len(hops)-1])
+	}
+	return hop, nil
+}
+
+// checkJumpCycle 沿跳板链检查是否存在循环引用
+func checkJumpCycle(conn *config.Connection) error {
+	var path []string
+	for current := conn; ; {
+		name := current.Alias
+		if name == "" {
+			name = current.Host
+		}
+		if current.Alias != "" && slices.Contains(path, current.Alias) {
+			return fmt.Errorf("jump host cycle detected: %s -> %s", strings.Join(path, " -> "), current.Alias)
+		}
+		path = append(path, name)
+
+		if len(current.Jump) == 0 {
+			return nil
+		}
+		hop, err := lastJumpHop(current)
+		if err != nil {
+			return err
+		}
+		current = hop
+	}
+}
+
+// dialViaJump 通过连接池中的跳板连接打开到目标地址的 direct-tcpip 通道
+func dialViaJump(conn *config.Connection, addr string, timeout time.Duration, pr prompt.Prompter) (net.Conn, error) {
+	hop, err := lastJumpHop(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	client, release, err := GetConnectionPool().acquireClient(hop, nil, pr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to jump host '%s': %w", hop.Alias, err)
+	}
+	debugf("connecting to %s via jump host %s", addr, hop.Alias)
+
+	// client.Dial 不支持超时，超时后在后台关闭迟到的连接
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, err := client.Dial("tcp", addr)
+		done <- result{c, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			release()
+			return nil, fmt.Errorf("jump host '%s' unable to reach %s: %w", hop.Alias, addr, r.err)
+		}
+		return &releasingConn{Conn: r.conn, release: release}, nil
+	case <-time.After(timeout):
+		release()
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, &jumpTimeoutError{hop: hop.Alias, addr: addr}
+	}
+}
+
+// jumpTimeoutError 跳板机在超时时间内没有建立到目标地址的连接，实现 net.Error 以便重试
+type jumpTimeoutError struct {
+	hop  string
+	addr string
+}
+
+func (e *jumpTimeoutError) Error() string {
+	return fmt.Sprintf("jump host '%s' timed out connecting to %s", e.hop, e.addr)
+}
+func (e *jumpTimeoutError) Timeout() bool   { return true }
+func (e *jumpTimeoutError) Temporary() bool { return true }
+
+// releasingConn 跳板机上的通道连接，关闭时释放对跳板连接的占用
+type releasingConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *releasingConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
@@ -0,0 +1,122 @@
+package ssh
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+	"golang.org/x/crypto/ssh"
+)
+
+// keyboard-interactive 自动应答的答案类型
+const (
+	ChallengeAnswerTOTP     = "totp"
+	ChallengeAnswerPassword = "password"
+	challengeTextPrefix     = "text:"
+)
+
+// 未配置规则时使用的默认匹配：验证码类问题使用 TOTP，密码类问题使用凭证密码
+var defaultChallenges = []config.Challenge{
+	{Prompt: `verification|one-time|otp|token|code|2fa|authenticator`, Answer: ChallengeAnswerTOTP},
+	{Prompt: `password`, Answer: ChallengeAnswerPassword},
+}
+
+// ValidateChallenge 校验 keyboard-interactive 应答规则
+func ValidateChallenge(challenge config.Challenge) error {
+	if _, err := regexp.Compile("(?i)" + challenge.Prompt); err != nil {
+		return fmt.Errorf("invalid challenge prompt pattern '%s': %w", challenge.Prompt, err)
+	}
+	switch {
+	case challenge.Answer == ChallengeAnswerTOTP, challenge.Answer == ChallengeAnswerPassword,
+		strings.HasPrefix(challenge.Answer, challengeTextPrefix):
+		return nil
+	default:
+		return fmt.Errorf("invalid challenge answer '%s': must be totp, password or text:<answer>", challenge.Answer)
+	}
+}
+
+// challengeAnswer 按规则为问题生成答案，ok 为 false 表示没有规则可以应答
+func challengeAnswer(cred *config.Credential, question string) (answer string, ok bool, err error) {
+	var rules []config.Challenge
+	if cred != nil {
+		rules = append(rules, cred.Challenges...)
+	}
+	rules = append(rules, defaultChallenges...)
+
+	for _, rule := range rules {
+		re, err := regexp.Compile("(?i)" + rule.Prompt)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid challenge prompt pattern '%s': %w", rule.Prompt, err)
+		}
+		if !re.MatchString(question) {
+			continue
+		}
+
+		switch {
+		case rule.Answer == ChallengeAnswerTOTP:
+			if cred == nil || cred.TOTPSecret == "" {
+				continue
+			}
+			answer, err := GenerateTOTP(cred.TOTPSecret, time.Now())
+			return answer, err == nil, err
+		case rule.Answer == ChallengeAnswerPassword:
+			if cred == nil || (cred.Password == "" && cred.PasswordCommand == "") {
+				continue
+			}
+			answer, err := credentialPassword(cred)
+			return answer, err == nil, err
+		case strings.HasPrefix(rule.Answer, challengeTextPrefix):
+			return strings.TrimPrefix(rule.Answer, challengeTextPrefix), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// keyboardInteractive 返回 keyboard-interactive 认证回调：
+// 优先按凭证规则自动应答，无法应答的问题在终端上提示用户输入
+func keyboardInteractive(cred *config.Credential, pr prompt.Prompter) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		shownHeader := false
+
+		for i, question := range questions {
+			answer, ok, err := challengeAnswer(cred, question)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				answers[i] = answer
+				continue
+			}
+
+			if !pr.IsInteractive() {
+				return nil, fmt.Errorf("no answer configured for keyboard-interactive prompt %q, add a challenge rule to the credential", strings.TrimSpace(question))
+			}
+
+			if !shownHeader {
+				shownHeader = true
+				if name != "" {
+					pr.Print(name + "\n")
+				}
+				if instruction != "" {
+					pr.Print(instruction + "\n")
+				}
+			}
+
+			if echos[i] {
+				answer, err = pr.Line(question)
+			} else {
+				answer, err = pr.Password(question)
+			}
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		}
+
+		return answers, nil
+	}
+}
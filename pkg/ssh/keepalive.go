@@ -0,0 +1,82 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// 心跳和空闲超时的默认值
+const (
+	defaultKeepaliveInterval = 30 * time.Second
+	defaultKeepaliveCountMax = 3
+	defaultIdleTimeout       = 30 * time.Minute
+)
+
+// errKeepaliveTimeout 心跳请求在超时时间内没有收到响应
+var errKeepaliveTimeout = errors.New("keepalive timed out")
+
+// keepaliveSettings 连接池中一个连接的心跳和空闲超时配置，时间为 0 表示不启用
+type keepaliveSettings struct {
+	interval    time.Duration
+	countMax    int
+	idleTimeout time.Duration
+}
+
+// ValidateKeepalive 检查心跳和空闲超时配置是否有效
+func ValidateKeepalive(conn *config.Connection) error {
+	_, err := keepaliveConfig(conn)
+	return err
+}
+
+// keepaliveConfig 解析连接的心跳和空闲超时配置，未配置的字段使用默认值
+func keepaliveConfig(conn *config.Connection) (keepaliveSettings, error) {
+	settings := keepaliveSettings{
+		interval:    defaultKeepaliveInterval,
+		countMax:    defaultKeepaliveCountMax,
+		idleTimeout: defaultIdleTimeout,
+	}
+	if conn.KeepaliveInterval != "" {
+		interval, err := time.ParseDuration(conn.KeepaliveInterval)
+		if err != nil || interval < 0 {
+			return settings, fmt.Errorf("invalid keepalive_interval value '%s'", conn.KeepaliveInterval)
+		}
+		settings.interval = interval
+	}
+	if conn.KeepaliveCountMax < 0 {
+		return settings, fmt.Errorf("invalid keepalive_count_max %d: must not be negative", conn.KeepaliveCountMax)
+	}
+	if conn.KeepaliveCountMax > 0 {
+		settings.countMax = conn.KeepaliveCountMax
+	}
+	if conn.IdleTimeout != "" {
+		idle, err := time.ParseDuration(conn.IdleTimeout)
+		if err != nil || idle < 0 {
+			return settings, fmt.Errorf("invalid idle_timeout value '%s'", conn.IdleTimeout)
+		}
+		settings.idleTimeout = idle
+	}
+	return settings, nil
+}
+
+// sendKeepalive 发送一次 keepalive@openssh.com 请求，超过 timeout 未收到响应时返回 errKeepaliveTimeout。
+// 超时后请求仍在后台等待，直到连接关闭
+func sendKeepalive(client *ssh.Client, timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		return errKeepaliveTimeout
+	}
+}
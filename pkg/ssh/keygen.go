@@ -0,0 +1,165 @@
+package ssh
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+	"golang.org/x/crypto/ssh"
+)
+
+// 支持生成的密钥类型
+const (
+	KeyTypeEd25519 = "ed25519"
+	KeyTypeRSA     = "rsa"
+	KeyTypeECDSA   = "ecdsa"
+)
+
+// RSA 密钥的默认和最小长度
+const (
+	defaultRSABits = 3072
+	minRSABits     = 2048
+)
+
+// GeneratedKey 保存新生成的密钥对
+type GeneratedKey struct {
+	PrivateKey []byte // OpenSSH 格式的私钥（PEM）
+	PublicKey  []byte // authorized_keys 格式的公钥
+	Signer     ssh.Signer
+}
+
+// GenerateKey 生成指定类型的密钥对，bits 为 0 时使用默认长度，passphrase 非空时加密私钥
+func GenerateKey(keyType string, bits int, passphrase, comment string) (*GeneratedKey, error) {
+	var key crypto.PrivateKey
+	var err error
+
+	switch keyType {
+	case KeyTypeEd25519:
+		if bits != 0 {
+			return nil, fmt.Errorf("ed25519 keys have a fixed length, --bits is not supported")
+		}
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case KeyTypeRSA:
+		if bits == 0 {
+			bits = defaultRSABits
+		}
+		if bits < minRSABits {
+			return nil, fmt.Errorf("rsa keys must be at least %d bits", minRSABits)
+		}
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+	case KeyTypeECDSA:
+		var curve elliptic.Curve
+		switch bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("invalid ecdsa key length %d: must be 256, 384 or 521", bits)
+		}
+		key, err = ecdsa.GenerateKey(curve, rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s': must be ed25519, rsa or ecdsa", keyType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate %s key: %w", keyType, err)
+	}
+
+	return encodeKey(key, passphrase, comment)
+}
+
+// encodeKey 将私钥编码为 OpenSSH 格式，passphrase 非空时加密
+func encodeKey(key crypto.PrivateKey, passphrase, comment string) (*GeneratedKey, error) {
+	var block *pem.Block
+	var err error
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, comment, []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(key, comment)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode private key: %w", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create signer: %w", err)
+	}
+
+	return &GeneratedKey{
+		PrivateKey: pem.EncodeToMemory(block),
+		PublicKey:  authorizedKeyLine(signer.PublicKey(), comment),
+		Signer:     signer,
+	}, nil
+}
+
+// authorizedKeyLine 返回带注释的 authorized_keys 格式公钥行
+func authorizedKeyLine(key ssh.PublicKey, comment string) []byte {
+	line := ssh.MarshalAuthorizedKey(key)
+	if comment == "" {
+		return line
+	}
+	return append(line[:len(line)-1], []byte(" "+comment+"\n")...)
+}
+
+// CredentialPublicKey 返回 key 类型凭证的公钥和注释：优先读取同名 .pub 文件，
+// 不存在时从私钥推导（私钥已加密时需要密码）
+func CredentialPublicKey(cred *config.Credential) (ssh.PublicKey, string, error) {
+	if cred.Type != "key" {
+		return nil, "", fmt.Errorf("credential type '%s' has no key file, only key credentials can be deployed", cred.Type)
+	}
+
+	if data, err := os.ReadFile(os.ExpandEnv(cred.KeyPath) + ".pub"); err == nil {
+		key, comment, _, _, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to parse public key %s.pub: %w", cred.KeyPath, err)
+		}
+		return key, comment, nil
+	}
+
+	signer, err := (&authConfig{prompter: prompt.Current()}).credentialSigner(cred)
+	if err != nil {
+		return nil, "", err
+	}
+	return signer.PublicKey(), cred.Alias, nil
+}
+
+// ExportCredentialKey 将凭证的私钥（包括 PuTTY .ppk）导出为 OpenSSH 格式。
+// passphrase 为 nil 时沿用解密原私钥使用的密码
+func ExportCredentialKey(cred *config.Credential, passphrase *string, comment string) (*GeneratedKey, error) {
+	if cred.Type != "key" {
+		return nil, fmt.Errorf("credential type '%s' has no key file, only key credentials can be converted", cred.Type)
+	}
+
+	// 记录解密原私钥时使用的密码
+	var used string
+	getPassphrase := (&authConfig{prompter: prompt.Current()}).credentialPassphrase(cred)
+	key, err := loadPrivateKey(cred.KeyPath, func(retry bool) (string, error) {
+		password, err := getPassphrase(retry)
+		used = password
+		return password, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == nil {
+		passphrase = &used
+	}
+
+	// OpenSSH 格式解析出的 ed25519 私钥是指针，统一为值类型
+	if k, ok := key.(*ed25519.PrivateKey); ok {
+		key = *k
+	}
+
+	return encodeKey(key, *passphrase, comment)
+}
@@ -0,0 +1,80 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
+	"golang.org/x/crypto/ssh"
+)
+
+// passwordPrompter 对所有密码提示返回固定的答案
+type passwordPrompter struct {
+	password string
+	asked    int
+}
+
+func (p *passwordPrompter) IsInteractive() bool { return true }
+
+func (p *passwordPrompter) Line(string) (string, error) { return "", nil }
+
+func (p *passwordPrompter) Password(string) (string, error) {
+	p.asked++
+	return p.password, nil
+}
+
+func (p *passwordPrompter) Print(string) {}
+
+// writeEncryptedKey 生成用 passphrase 加密、没有 .pub 文件的私钥，返回引用它的凭证
+func writeEncryptedKey(t *testing.T, passphrase string) *config.Credential {
+	t.Helper()
+	key, err := GenerateKey(KeyTypeEd25519, 0, passphrase, "test")
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, key.PrivateKey, 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return &config.Credential{Alias: "enc", Type: "key", KeyPath: path}
+}
+
+func TestExportCredentialKeyPromptsForPassphrase(t *testing.T) {
+	cred := writeEncryptedKey(t, "secret")
+	pr := &passwordPrompter{password: "secret"}
+	defer prompt.SetPrompter(pr)()
+
+	exported, err := ExportCredentialKey(cred, nil, "exported")
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if pr.asked != 1 {
+		t.Errorf("asked for the passphrase %d times, want 1", pr.asked)
+	}
+
+	// 未指定新密码时沿用原私钥的密码
+	if _, err := ssh.ParseRawPrivateKey(exported.PrivateKey); err == nil {
+		t.Error("exported key is not encrypted, want the original passphrase")
+	}
+	if _, err := ssh.ParseRawPrivateKeyWithPassphrase(exported.PrivateKey, []byte("secret")); err != nil {
+		t.Errorf("exported key does not open with the original passphrase: %v", err)
+	}
+}
+
+func TestExportCredentialKeyWithoutTerminal(t *testing.T) {
+	cred := writeEncryptedKey(t, "secret")
+	defer prompt.SetPrompter(noPrompter{})()
+
+	_, err := ExportCredentialKey(cred, nil, "exported")
+	if err == nil || !strings.Contains(err.Error(), "is encrypted") {
+		t.Errorf("error = %v, want a hint that the key is encrypted", err)
+	}
+
+	// 从私钥推导公钥走同样的路径
+	if _, _, err := CredentialPublicKey(cred); err == nil || !strings.Contains(err.Error(), "is encrypted") {
+		t.Errorf("public key error = %v, want a hint that the key is encrypted", err)
+	}
+}
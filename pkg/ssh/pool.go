@@ -2,26 +2,81 @@ package ssh
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"golang.org/x/net/proxy"
+	"io"
 	"net"
-	"net/http"
-	"net/url"
-	"os"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/justseemore/sshm/pkg/config"
+	"github.com/justseemore/sshm/pkg/prompt"
 	"golang.org/x/crypto/ssh"
 )
 
-// ConnectionPool 管理SSH连接池
+// 会话异常结束后等待底层连接关闭的时间，用于判断是否是连接断开导致的
+const connectionLostGrace = time.Second
+
+// 连接池主动关闭连接的原因
+var (
+	errIdleTimeout = errors.New("closed after idle timeout")
+	errPoolClosed  = errors.New("connection pool closed")
+	errEvicted     = errors.New("closed to stay within the connection limit")
+)
+
+// Shutdown 检查会话和通道是否都已结束的间隔
+const shutdownPollInterval = 100 * time.Millisecond
+
+// DefaultMaxConnections 连接池默认最多保持的连接数
+const DefaultMaxConnections = 32
+
+// ConnectionPool 管理SSH连接池。同一个键同时只建立一个连接，并发请求等待并共享其结果；
+// 连接数超过上限时关闭最久未使用的空闲连接
 type ConnectionPool struct {
-	connections map[string]*ssh.Client
-	mutex       sync.RWMutex
-	// 连接最后使用时间，用于清理过期连接
-	lastUsed map[string]time.Time
+	connections map[string]*pooledClient
+	// 正在建立的连接
+	dialing map[string]*dialCall
+	mutex   sync.RWMutex
+	// 最多保持的连接数，0 表示不限制
+	maxConnections int
+	// 关闭后不再建立新连接
+	closed bool
+}
+
+// dialCall 一次正在进行的连接建立，完成后关闭 done
+type dialCall struct {
+	done  chan struct{}
+	entry *pooledClient
+	err   error
+}
+
+// pooledClient 连接池中的一个连接及其使用状态
+type pooledClient struct {
+	key      string
+	client   *ssh.Client
+	settings keepaliveSettings
+
+	// 连接建立的时间
+	connected time.Time
+	// 底层连接的流量统计，没有统计时为 nil
+	meter *meteredConn
+
+	// 以下字段由连接池的锁保护
+	// 最后使用时间，用于关闭空闲连接和选择淘汰的连接
+	lastUsed time.Time
+	// 正在使用的会话和通道数，大于 0 时不会因空闲或超出上限被关闭
+	channels int
+	// 连接池主动关闭连接的原因，如心跳无响应或空闲超时
+	closeErr error
+	// 最近一次心跳的往返时间
+	rtt time.Duration
+
+	// 客户端连接关闭后 done 被关闭，waitErr 为连接关闭的原因
+	done    chan struct{}
+	waitErr error
+	// 经由后台守护进程的连接，本地连接为 nil
+	daemon *daemonLink
 }
 
 // 全局连接池实例
@@ -33,321 +88,603 @@ var (
 // GetConnectionPool 返回全局连接池实例
 func GetConnectionPool() *ConnectionPool {
 	globalPoolOnce.Do(func() {
-		globalPool = &ConnectionPool{
-			connections: make(map[string]*ssh.Client),
-			lastUsed:    make(map[string]time.Time),
-		}
-		// 启动定期清理过期连接的goroutine
-		go globalPool.cleanupExpiredConnections()
+		globalPool = NewConnectionPool(DefaultMaxConnections)
 	})
 	return globalPool
 }
 
-// 生成连接的唯一键
+// NewConnectionPool 创建最多保持 maxConnections 个连接的连接池，0 表示不限制
+func NewConnectionPool(maxConnections int) *ConnectionPool {
+	return &ConnectionPool{
+		connections:    make(map[string]*pooledClient),
+		dialing:        make(map[string]*dialCall),
+		maxConnections: maxConnections,
+	}
+}
+
+// SetMaxConnections 设置最多保持的连接数，0 表示不限制。超出的空闲连接立即关闭
+func (p *ConnectionPool) SetMaxConnections(n int) {
+	p.mutex.Lock()
+	p.maxConnections = n
+	p.mutex.Unlock()
+	p.enforceLimit(nil)
+}
+
+// generateConnectionKey 生成连接的唯一键。键包含实际连接的路径（地址、地址族、跳板机、代理）和认证身份
+// （用户名、凭证或私钥），只有这些都相同的请求才会共用连接；代理地址中的密码不会出现在键中
 func generateConnectionKey(conn *config.Connection, cred *config.Credential) string {
 	user := conn.User
 	if cred != nil && cred.Username != "" {
 		user = cred.Username
 	}
-	return fmt.Sprintf("%s@%s:%d", user, conn.Host, conn.Port)
+	parts := []string{fmt.Sprintf("%s@%s", user, connectionAddr(conn))}
+
+	// 认证身份
+	switch chain := conn.CredentialChain(); {
+	case cred != nil && cred.Alias != "":
+		parts = append(parts, "cred="+cred.Alias)
+	case cred != nil:
+		parts = append(parts, "cred="+credentialIdentity(cred))
+	case len(chain) > 0:
+		// 使用凭证链时，实际的用户名和密钥取决于认证成功的凭证
+		parts = append(parts, "creds="+strings.Join(chain, ","))
+	case conn.IdentityFile != "":
+		parts = append(parts, "identity="+conn.IdentityFile)
+	}
+
+	// 实际连接的地址和路径
+	if len(conn.Hosts) > 0 {
+		parts = append(parts, "hosts="+strings.Join(conn.Addresses(), ","))
+	}
+	if conn.AddressFamily != "" && conn.AddressFamily != AddressFamilyAny {
+		parts = append(parts, "family="+conn.AddressFamily)
+	}
+	switch {
+	case len(conn.Jump) > 0:
+		parts = append(parts, "via "+strings.Join(conn.Jump, ","))
+	case conn.ProxyCommand != "":
+		parts = append(parts, "via command "+conn.ProxyCommand)
+	default:
+		// 解析失败时使用原始配置，拨号时会报告错误
+		if proxyURL, err := resolveProxy(conn); err != nil {
+			parts = append(parts, "via proxy "+conn.Proxy)
+		} else if proxyURL != nil {
+			parts = append(parts, "via proxy "+proxyURL.Redacted())
+		}
+	}
+	return strings.Join(parts, " ")
 }
 
-// GetClient 从连接池获取客户端，如果不存在则创建新的
+// credentialIdentity 描述没有别名的凭证使用的认证方式
+func credentialIdentity(cred *config.Credential) string {
+	switch {
+	case cred.KeyPath != "":
+		return cred.Type + ":" + cred.KeyPath
+	case cred.AgentKey != "":
+		return cred.Type + ":" + cred.AgentKey
+	default:
+		return cred.Type
+	}
+}
+
+// GetClient 从连接池获取客户端，如果不存在则创建新的。并发请求同一个键时只建立一个连接。
+// 返回的客户端在没有会话时可能因空闲或超出上限被关闭，需要立即使用时请用 AcquireClient
 func (p *ConnectionPool) GetClient(conn *config.Connection, cred *config.Credential) (*ssh.Client, error) {
+	entry, err := p.get(conn, cred, false, prompt.Current())
+	if err != nil {
+		return nil, err
+	}
+	return entry.client, nil
+}
+
+// AcquireClient 与 GetClient 相同，但同时记录一个正在使用的会话或通道，使用期间连接不会被关闭。
+// 返回的函数在会话或通道关闭后调用
+func (p *ConnectionPool) AcquireClient(conn *config.Connection, cred *config.Credential) (*ssh.Client, func(), error) {
+	return p.acquireClient(conn, cred, prompt.Current())
+}
+
+// acquireClient 与 AcquireClient 相同，建立连接时需要的输入通过 pr 获取
+func (p *ConnectionPool) acquireClient(conn *config.Connection, cred *config.Credential, pr prompt.Prompter) (*ssh.Client, func(), error) {
+	entry, err := p.get(conn, cred, true, pr)
+	if err != nil {
+		return nil, nil, err
+	}
+	var once sync.Once
+	return entry.client, func() {
+		once.Do(func() { p.release(entry) })
+	}, nil
+}
+
+// get 获取或建立连接，hold 为 true 时在返回前增加通道计数。建立连接时需要的输入通过 pr 获取
+func (p *ConnectionPool) get(conn *config.Connection, cred *config.Credential, hold bool, pr prompt.Prompter) (*pooledClient, error) {
+	settings, err := keepaliveConfig(conn)
+	if err != nil {
+		return nil, err
+	}
 	key := generateConnectionKey(conn, cred)
 
-	// 先尝试从池中获取现有连接
-	p.mutex.RLock()
-	client, exists := p.connections[key]
-	p.mutex.RUnlock()
+	for {
+		p.mutex.Lock()
+		if p.closed {
+			p.mutex.Unlock()
+			return nil, errPoolClosed
+		}
 
-	if exists {
-		// 测试连接是否仍然有效
-		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
-		if err == nil {
-			// 更新最后使用时间
-			p.mutex.Lock()
-			p.lastUsed[key] = time.Now()
+		// 先尝试从池中获取现有连接
+		if entry, exists := p.connections[key]; exists {
 			p.mutex.Unlock()
-			return client, nil
+			// 测试连接是否仍然有效，对端无响应时不会一直等待
+			timeout, err := connectTimeout(conn)
+			if err != nil {
+				timeout = defaultConnectTimeout
+			}
+			start := time.Now()
+			if err := sendKeepalive(entry.client, timeout); err != nil {
+				// 连接已失效，从池中移除后重新建立
+				debugf("pooled connection %s is no longer alive, reconnecting", key)
+				p.closeEntry(entry, errors.New("no response to keepalive"))
+				continue
+			}
+			p.recordRTT(entry, time.Since(start))
+			if p.use(entry, hold) {
+				return entry, nil
+			}
+			// 检查期间连接已被关闭
+			continue
+		}
+
+		// 其他请求正在建立同一个连接时等待其结果
+		if call, dialing := p.dialing[key]; dialing {
+			p.mutex.Unlock()
+			<-call.done
+			if call.err != nil {
+				return nil, call.err
+			}
+			if p.use(call.entry, hold) {
+				return call.entry, nil
+			}
+			continue
 		}
-		// 连接已失效，从池中移除
+
+		call := &dialCall{done: make(chan struct{})}
+		p.dialing[key] = call
+		p.mutex.Unlock()
+
+		call.entry, call.err = p.dial(key, conn, cred, settings, hold, pr)
 		p.mutex.Lock()
-		delete(p.connections, key)
-		delete(p.lastUsed, key)
+		delete(p.dialing, key)
 		p.mutex.Unlock()
+		close(call.done)
+		return call.entry, call.err
 	}
+}
 
-	// 创建新的SSH连接
-	client, err := createSSHClient(conn, cred)
-	if err != nil {
-		return nil, err
+// use 连接仍在池中时更新最后使用时间，hold 为 true 时增加通道计数
+func (p *ConnectionPool) use(entry *pooledClient, hold bool) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.connections[entry.key] != entry {
+		return false
+	}
+	entry.lastUsed = time.Now()
+	if hold {
+		entry.channels++
 	}
+	return true
+}
 
-	// 将新连接添加到池中
-	p.mutex.Lock()
-	p.connections[key] = client
-	p.lastUsed[key] = time.Now()
-	p.mutex.Unlock()
+// dial 建立新连接并加入连接池
+func (p *ConnectionPool) dial(key string, conn *config.Connection, cred *config.Credential, settings keepaliveSettings, hold bool, pr prompt.Prompter) (*pooledClient, error) {
+	// 有后台守护进程运行时由守护进程建立并保持连接。守护进程的连接由多个 sshm 进程共用，
+	// 转发 agent 的连接需要独占连接，直接建立
+	var client *ssh.Client
+	var link *daemonLink
+	var err error
+	if !daemonMode && conn.ControlPersist != ControlPersistNo && !conn.ForwardAgent {
+		client, link, err = attachDaemon(conn, cred, pr)
+		if err != nil && !errors.Is(err, ErrDaemonNotRunning) {
+			return nil, err
+		}
+	}
 
-	// 启动心跳保持连接活跃
-	go p.keepAlive(client, key)
+	// 创建新的SSH连接，未指定凭证时依次尝试连接的凭证链
+	if client == nil {
+		if chain := conn.CredentialChain(); cred == nil && len(chain) > 0 {
+			client, err = createChainedClient(conn, chain, pr)
+		} else {
+			client, err = createSSHClient(conn, cred, pr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return client, nil
+	// 将新连接添加到池中
+	entry, err := p.add(key, client, settings, link, hold)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	// 新连接即将返回给调用方，即使尚未记录通道也不能被淘汰
+	p.enforceLimit(entry)
+	return entry, nil
 }
 
-// 保持连接活跃的心跳
-func (p *ConnectionPool) keepAlive(client *ssh.Client, key string) {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+// add 将新连接加入连接池，并启动心跳和空闲检测。连接池已关闭时返回错误
+func (p *ConnectionPool) add(key string, client *ssh.Client, settings keepaliveSettings, link *daemonLink, hold bool) (*pooledClient, error) {
+	now := time.Now()
+	entry := &pooledClient{
+		key:       key,
+		client:    client,
+		settings:  settings,
+		connected: now,
+		meter:     meterFor(client),
+		lastUsed:  now,
+		done:      make(chan struct{}),
+		daemon:    link,
+	}
+	if hold {
+		entry.channels = 1
+	}
+
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil, errPoolClosed
+	}
+	p.connections[key] = entry
+	p.mutex.Unlock()
 
-	// 添加一个检测客户端是否已关闭的通道
-	closed := make(chan struct{})
 	go func() {
-		// 这个goroutine会在客户端关闭时退出
-		_, _, _ = client.SendRequest("keepalive@openssh.com", true, nil)
-		close(closed)
+		// 客户端连接关闭时返回
+		entry.waitErr = client.Wait()
+		close(entry.done)
 	}()
+	go p.monitor(entry)
+	return entry, nil
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			// 检查连接是否仍在池中
-			p.mutex.RLock()
-			_, exists := p.connections[key]
-			p.mutex.RUnlock()
-			if !exists {
-				return
-			}
-
-			// 发送心跳
-			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
-			if err != nil {
-				// 连接已断开，从池中移除
-				p.mutex.Lock()
-				delete(p.connections, key)
-				delete(p.lastUsed, key)
-				p.mutex.Unlock()
-				return
+// enforceLimit 连接数超过上限时，按最后使用时间从旧到新关闭空闲连接。
+// 正在使用的连接和 keep 不会被关闭，全部在使用时暂时允许超出上限
+func (p *ConnectionPool) enforceLimit(keep *pooledClient) {
+	p.mutex.Lock()
+	var evicted []*pooledClient
+	for p.maxConnections > 0 && len(p.connections) > p.maxConnections {
+		var oldest *pooledClient
+		for _, entry := range p.connections {
+			if entry != keep && entry.channels == 0 && (oldest == nil || entry.lastUsed.Before(oldest.lastUsed)) {
+				oldest = entry
 			}
-
-			// 更新最后使用时间
-			p.mutex.Lock()
-			p.lastUsed[key] = time.Now()
-			p.mutex.Unlock()
-		case <-closed:
-			// 客户端已关闭，从池中移除
-			p.mutex.Lock()
-			delete(p.connections, key)
-			delete(p.lastUsed, key)
-			p.mutex.Unlock()
-			return
 		}
+		if oldest == nil {
+			break
+		}
+		oldest.closeErr = errEvicted
+		delete(p.connections, oldest.key)
+		evicted = append(evicted, oldest)
+	}
+	p.mutex.Unlock()
+
+	for _, entry := range evicted {
+		debugf("closing least recently used connection %s", entry.key)
+		entry.client.Close()
 	}
 }
 
-// 清理过期连接
-func (p *ConnectionPool) cleanupExpiredConnections() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
+// Close 立即关闭连接池中的所有连接，之后 GetClient 返回错误
+func (p *ConnectionPool) Close() error {
+	p.mutex.Lock()
+	p.closed = true
+	entries := make([]*pooledClient, 0, len(p.connections))
+	for key, entry := range p.connections {
+		entry.closeErr = errPoolClosed
+		entries = append(entries, entry)
+		delete(p.connections, key)
+	}
+	p.mutex.Unlock()
 
-	for {
-		<-ticker.C
-		now := time.Now()
+	for _, entry := range entries {
+		entry.client.Close()
+	}
+	return nil
+}
 
-		p.mutex.Lock()
-		for key, lastUsed := range p.lastUsed {
-			// 如果连接超过30分钟未使用，关闭并移除
-			if now.Sub(lastUsed) > 30*time.Minute {
-				if client, exists := p.connections[key]; exists {
-					client.Close()
-					delete(p.connections, key)
-					delete(p.lastUsed, key)
-				}
-			}
+// Shutdown 停止建立新连接，等待所有会话和通道结束后关闭连接池中的连接。
+// ctx 先结束时立即关闭剩余连接并返回 ctx.Err()
+func (p *ConnectionPool) Shutdown(ctx context.Context) error {
+	p.mutex.Lock()
+	p.closed = true
+	p.mutex.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for p.activeChannels() > 0 {
+		select {
+		case <-ctx.Done():
+			p.Close()
+			return ctx.Err()
+		case <-ticker.C:
 		}
-		p.mutex.Unlock()
 	}
+	return p.Close()
 }
 
-// 创建新的SSH客户端连接
-func createSSHClient(conn *config.Connection, cred *config.Credential) (*ssh.Client, error) {
-	// 这里复用现有的SSH客户端创建逻辑，但不包括交互式会话部分
-	// 创建SSH客户端配置
-	clientConfig := &ssh.ClientConfig{
-		User:            conn.User,
-		Auth:            []ssh.AuthMethod{},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+// activeChannels 返回所有连接上正在使用的会话和通道总数
+func (p *ConnectionPool) activeChannels() int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	total := 0
+	for _, entry := range p.connections {
+		total += entry.channels
 	}
+	return total
+}
 
-	// 使用凭证中的认证信息（如果提供）
-	if cred != nil {
-		// 使用凭证中的用户名（如果有）
-		if cred.Username != "" {
-			clientConfig.User = cred.Username
-		}
+// remove 从连接池移除连接，键已对应其他连接时不做处理
+func (p *ConnectionPool) remove(entry *pooledClient) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.connections[entry.key] == entry {
+		delete(p.connections, entry.key)
+	}
+}
 
-		// 根据凭证类型添加认证方法
-		if cred.Type == "key" {
-			// 添加私钥认证
-			expandedPath := os.ExpandEnv(cred.KeyPath)
-			key, err := os.ReadFile(expandedPath)
-			if err != nil {
-				return nil, fmt.Errorf("unable to read private key: %w", err)
-			}
+// AcquireChannel 记录在连接池的客户端上打开了一个会话或通道，使用期间连接不会因空闲被关闭。
+// 返回的函数在会话或通道关闭后调用
+func (p *ConnectionPool) AcquireChannel(client *ssh.Client) func() {
+	entry := p.acquire(client)
+	var once sync.Once
+	return func() {
+		once.Do(func() { p.release(entry) })
+	}
+}
 
-			var signer ssh.Signer
-			if cred.KeyPassword != "" {
-				signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(cred.KeyPassword))
-			} else {
-				signer, err = ssh.ParsePrivateKey(key)
-			}
+// acquire 增加客户端的通道计数，客户端不在连接池中时返回 nil
+func (p *ConnectionPool) acquire(client *ssh.Client) *pooledClient {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	entry := p.lookup(client)
+	if entry != nil {
+		entry.channels++
+		entry.lastUsed = time.Now()
+	}
+	return entry
+}
 
-			if err != nil {
-				return nil, fmt.Errorf("unable to parse private key: %w", err)
-			}
+// entryFor 返回客户端在连接池中的记录，不在连接池中时返回 nil
+func (p *ConnectionPool) entryFor(client *ssh.Client) *pooledClient {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.lookup(client)
+}
 
-			clientConfig.Auth = append(clientConfig.Auth, ssh.PublicKeys(signer))
-		} else if cred.Type == "password" {
-			// 添加密码认证
-			clientConfig.Auth = append(clientConfig.Auth, ssh.Password(cred.Password))
+// lookup 查找客户端对应的记录，调用方需持有锁
+func (p *ConnectionPool) lookup(client *ssh.Client) *pooledClient {
+	for _, entry := range p.connections {
+		if entry.client == client {
+			return entry
 		}
-	} else {
-		// 使用连接配置中的认证信息
-		if conn.Password != "" {
-			clientConfig.Auth = append(clientConfig.Auth, ssh.Password(conn.Password))
-		}
-
-		if conn.IdentityFile != "" {
-			expandedPath := os.ExpandEnv(conn.IdentityFile)
-			key, err := os.ReadFile(expandedPath)
-			if err != nil {
-				return nil, fmt.Errorf("unable to read private key: %w", err)
-			}
+	}
+	return nil
+}
 
-			signer, err := ssh.ParsePrivateKey(key)
-			if err != nil {
-				return nil, fmt.Errorf("unable to parse private key: %w", err)
-			}
+// size 返回连接池中的连接数
+func (p *ConnectionPool) size() int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return len(p.connections)
+}
 
-			clientConfig.Auth = append(clientConfig.Auth, ssh.PublicKeys(signer))
-		}
+// release 减少通道计数，空闲时间从最后一个通道关闭时开始计算
+func (p *ConnectionPool) release(entry *pooledClient) {
+	if entry == nil {
+		return
 	}
+	p.mutex.Lock()
+	entry.channels--
+	entry.lastUsed = time.Now()
+	p.mutex.Unlock()
+	// 全部连接都在使用时可能暂时超出上限
+	p.enforceLimit(nil)
+}
 
-	// 设置超时
-	if conn.Timeout != "" {
-		timeout, err := time.ParseDuration(conn.Timeout)
-		if err != nil {
-			return nil, fmt.Errorf("invalid timeout value: %w", err)
-		}
-		clientConfig.Timeout = timeout
-	} else {
-		clientConfig.Timeout = 10 * time.Second // 默认超时
+// lostError 在会话异常结束后判断底层连接是否已断开，是则返回 connection lost 错误，否则返回 nil
+func (p *ConnectionPool) lostError(entry *pooledClient) error {
+	if entry == nil {
+		return nil
+	}
+	// 连接断开时会话先于客户端结束，稍等客户端关闭
+	select {
+	case <-entry.done:
+	case <-time.After(connectionLostGrace):
+		return nil
 	}
 
-	var client *ssh.Client
-	var err error
-	addr := fmt.Sprintf("%s:%d", conn.Host, conn.Port)
+	reason := p.closeReason(entry)
+	if reason == nil || errors.Is(reason, io.EOF) {
+		return errors.New("connection lost: connection closed by remote host")
+	}
+	return fmt.Errorf("connection lost: %w", reason)
+}
 
-	// 使用代理或直接连接
-	if conn.Proxy != "" {
-		// 解析代理URL
-		proxyURL, err := url.Parse(conn.Proxy)
-		if err != nil {
-			return nil, fmt.Errorf("unable to parse proxy URL: %w", err)
+// closeReason 返回连接关闭的原因：连接池主动关闭的原因、守护进程通知的原因或连接本身返回的错误。
+// 需在 entry.done 关闭后调用
+func (p *ConnectionPool) closeReason(entry *pooledClient) error {
+	p.mutex.RLock()
+	reason := entry.closeErr
+	p.mutex.RUnlock()
+	if reason == nil && entry.daemon != nil {
+		// 守护进程的通知在连接关闭前发出，等待其处理完成
+		select {
+		case <-entry.daemon.done:
+		case <-time.After(connectionLostGrace):
 		}
+		reason = entry.daemon.lostReason()
+	}
+	if reason == nil {
+		reason = entry.waitErr
+	}
+	return reason
+}
 
-		proxyType := proxyURL.Scheme
-		proxyHost := proxyURL.Hostname()
-		proxyPort, err := strconv.Atoi(proxyURL.Port())
-		if err != nil {
-			return nil, fmt.Errorf("invalid proxy port: %w", err)
-		}
+// monitor 按配置发送心跳并关闭空闲连接，连续 countMax 次心跳无响应或失败时判定连接已断开
+func (p *ConnectionPool) monitor(entry *pooledClient) {
+	settings := entry.settings
 
-		proxyUser := ""
-		proxyPassword := ""
-		if proxyURL.User != nil {
-			proxyUser = proxyURL.User.Username()
-			proxyPassword, _ = proxyURL.User.Password()
-		}
+	var tick <-chan time.Time
+	if settings.interval > 0 {
+		ticker := time.NewTicker(settings.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var idle <-chan time.Time
+	var idleTimer *time.Timer
+	if settings.idleTimeout > 0 {
+		idleTimer = time.NewTimer(settings.idleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
 
-		switch proxyType {
-		case "http":
-			// HTTP代理连接
-			httpProxyURL := &url.URL{
-				Scheme: "http",
-				Host:   fmt.Sprintf("%s:%d", proxyHost, proxyPort),
-			}
+	missed := 0
+	for {
+		select {
+		case <-entry.done:
+			// 客户端已关闭，从池中移除
+			p.remove(entry)
+			return
 
-			if proxyUser != "" {
-				httpProxyURL.User = url.UserPassword(proxyUser, proxyPassword)
+		case <-tick:
+			// 心跳不更新最后使用时间，否则空闲连接永远不会被关闭
+			start := time.Now()
+			err := sendKeepalive(entry.client, settings.interval)
+			if err == nil {
+				p.recordRTT(entry, time.Since(start))
+				missed = 0
+				continue
 			}
-
-			httpClient := &http.Client{
-				Transport: &http.Transport{
-					Proxy: http.ProxyURL(httpProxyURL),
-					DialContext: (&net.Dialer{
-						Timeout:   clientConfig.Timeout,
-						KeepAlive: 30 * time.Second,
-					}).DialContext,
-				},
+			if !errors.Is(err, errKeepaliveTimeout) {
+				p.markLost(entry, fmt.Errorf("keepalive failed: %w", err))
+				return
 			}
-
-			// 使用HTTP代理拨号
-			dialer := httpClient.Transport.(*http.Transport).DialContext
-			netConn, err := dialer(context.Background(), "tcp", addr)
-			if err != nil {
-				return nil, fmt.Errorf("unable to connect through HTTP proxy: %w", err)
+			missed++
+			debugf("no keepalive response from %s (%d/%d)", entry.key, missed, settings.countMax)
+			if missed >= settings.countMax {
+				p.markLost(entry, fmt.Errorf("no response to %d keepalive requests in %s",
+					missed, time.Duration(missed)*settings.interval))
+				return
 			}
 
-			// 使用建立的连接创建SSH客户端
-			conn, chans, reqs, err := ssh.NewClientConn(netConn, addr, clientConfig)
-			if err != nil {
-				netConn.Close()
-				return nil, fmt.Errorf("unable to create SSH client connection: %w", err)
-			}
-			client = ssh.NewClient(conn, chans, reqs)
-
-		case "socks5":
-			// SOCKS5代理连接
-			proxyAddr := fmt.Sprintf("%s:%d", proxyHost, proxyPort)
-			var auth *proxy.Auth
-
-			if proxyUser != "" {
-				auth = &proxy.Auth{
-					User:     proxyUser,
-					Password: proxyPassword,
-				}
+		case <-idle:
+			if remaining := p.idleRemaining(entry); remaining > 0 {
+				idleTimer.Reset(remaining)
+				continue
 			}
+			debugf("closing idle connection %s", entry.key)
+			p.closeEntry(entry, errIdleTimeout)
+			return
+		}
+	}
+}
 
-			dialer, err := proxy.SOCKS5("tcp", proxyAddr, auth, proxy.Direct)
-			if err != nil {
-				return nil, fmt.Errorf("unable to create SOCKS5 proxy dialer: %w", err)
-			}
+// idleRemaining 返回连接距离空闲超时的剩余时间，有通道在使用时重新开始计时
+func (p *ConnectionPool) idleRemaining(entry *pooledClient) time.Duration {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if entry.channels > 0 {
+		return entry.settings.idleTimeout
+	}
+	return entry.settings.idleTimeout - time.Since(entry.lastUsed)
+}
 
-			netConn, err := dialer.Dial("tcp", addr)
-			if err != nil {
-				return nil, fmt.Errorf("unable to connect through SOCKS5 proxy: %w", err)
-			}
+// markLost 记录连接断开的原因并关闭连接，正在使用该连接的会话随之结束
+func (p *ConnectionPool) markLost(entry *pooledClient, reason error) {
+	debugf("connection %s lost: %v", entry.key, reason)
+	p.closeEntry(entry, reason)
+}
 
-			// 使用建立的连接创建SSH客户端
-			conn, chans, reqs, err := ssh.NewClientConn(netConn, addr, clientConfig)
-			if err != nil {
-				netConn.Close()
-				return nil, fmt.Errorf("unable to create SSH client connection: %w", err)
-			}
-			client = ssh.NewClient(conn, chans, reqs)
+// closeEntry 记录关闭原因，从池中移除并关闭连接
+func (p *ConnectionPool) closeEntry(entry *pooledClient, reason error) {
+	p.mutex.Lock()
+	entry.closeErr = reason
+	if p.connections[entry.key] == entry {
+		delete(p.connections, entry.key)
+	}
+	p.mutex.Unlock()
+	entry.client.Close()
+}
 
-		default:
-			return nil, fmt.Errorf("unsupported proxy type: %s", proxyType)
-		}
-	} else {
-		// 直接连接（不使用代理）
-		client, err = ssh.Dial("tcp", addr, clientConfig)
-		if err != nil {
-			return nil, fmt.Errorf("unable to connect to SSH server: %w", err)
+// 创建新的SSH客户端连接
+func createSSHClient(conn *config.Connection, cred *config.Credential, pr prompt.Prompter) (*ssh.Client, error) {
+	return createClient(conn, pr, func() (*authConfig, error) {
+		return newAuthConfig(conn, cred, pr)
+	})
+}
+
+// createClient 使用 newAuth 构建的认证方法建立SSH客户端连接
+func createClient(conn *config.Connection, pr prompt.Prompter, newAuth func() (*authConfig, error)) (*ssh.Client, error) {
+	// 这里复用现有的SSH客户端创建逻辑，但不包括交互式会话部分
+	addr := connectionAddr(conn)
+
+	// 先校验算法配置，避免在输入密码后才发现配置错误
+	if err := ValidateAlgorithms(conn); err != nil {
+		return nil, err
+	}
+	if err := checkJumpCycle(conn); err != nil {
+		return nil, err
+	}
+	if err := ValidateFailover(conn); err != nil {
+		return nil, err
+	}
+	if err := ValidateAddressFamily(conn.AddressFamily); err != nil {
+		return nil, err
+	}
+
+	// 基于 known_hosts 校验主机密钥
+	checkHostKey, err := newHostKeyCallback(conn, pr)
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if err := checkHostKey(hostname, remote, key); err != nil {
+			return &hostKeyError{err: err}
 		}
+		return nil
+	}
+
+	// 根据凭证或连接配置构建认证方法
+	auth, err := newAuth()
+	if err != nil {
+		return nil, err
 	}
+	defer auth.Close()
 
+	// 创建SSH客户端配置
+	clientConfig := &ssh.ClientConfig{
+		User:            auth.user,
+		Auth:            auth.methods,
+		HostKeyCallback: hostKeyCallback,
+	}
+	applyAlgorithms(conn, clientConfig, addr)
+
+	// 设置超时
+	timeout, err := connectTimeout(conn)
+	if err != nil {
+		return nil, err
+	}
+	clientConfig.Timeout = timeout
+
+	// 使用代理或直接建立底层连接
+	netConn, dialedAddr, err := dialTarget(conn, auth.user, clientConfig.Timeout, pr)
+	if err != nil {
+		return nil, &dialError{err: err}
+	}
+
+	client, err := newClientConn(netConn, addr, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	auth.succeeded()
+	rememberAddress(conn, dialedAddr)
+	logNegotiatedAlgorithms(client)
 	return client, nil
 }
@@ -0,0 +1,130 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// errClosedByUser 连接被 sshm pool close 关闭
+var errClosedByUser = errors.New("closed by sshm pool close")
+
+// PoolEntryStats 连接池中一个连接的状态快照
+type PoolEntryStats struct {
+	Key string `json:"key"`
+	// 连接建立的时间
+	Connected time.Time `json:"connected"`
+	// 最后一次获取连接或通道关闭的时间
+	LastUsed time.Time `json:"last_used"`
+	// 正在使用的会话和通道数
+	Channels int `json:"channels"`
+	// 最近一次心跳的往返时间，尚未收到心跳响应时为 0
+	KeepaliveRTT time.Duration `json:"keepalive_rtt"`
+	// 底层连接发送和接收的字节数，经由守护进程的连接为与守护进程之间的流量
+	BytesSent     int64 `json:"bytes_sent"`
+	BytesReceived int64 `json:"bytes_received"`
+}
+
+// meteredConn 统计底层连接收发的字节数
+type meteredConn struct {
+	net.Conn
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.received.Add(int64(n))
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.sent.Add(int64(n))
+	return n, err
+}
+
+// 客户端到其底层连接流量统计的映射，客户端关闭后移除
+var meters sync.Map
+
+// meterClient 记录客户端的流量统计，直到客户端关闭
+func meterClient(client *ssh.Client, meter *meteredConn) {
+	meters.Store(client, meter)
+	go func() {
+		_ = client.Wait()
+		meters.Delete(client)
+	}()
+}
+
+// meterFor 返回客户端的流量统计，没有记录时返回 nil
+func meterFor(client *ssh.Client) *meteredConn {
+	if meter, ok := meters.Load(client); ok {
+		return meter.(*meteredConn)
+	}
+	return nil
+}
+
+// Snapshot 返回连接池中所有连接的状态，按键排序
+func (p *ConnectionPool) Snapshot() []PoolEntryStats {
+	p.mutex.RLock()
+	stats := make([]PoolEntryStats, 0, len(p.connections))
+	for _, entry := range p.connections {
+		s := PoolEntryStats{
+			Key:          entry.key,
+			Connected:    entry.connected,
+			LastUsed:     entry.lastUsed,
+			Channels:     entry.channels,
+			KeepaliveRTT: entry.rtt,
+		}
+		if entry.meter != nil {
+			s.BytesSent = entry.meter.sent.Load()
+			s.BytesReceived = entry.meter.received.Load()
+		}
+		stats = append(stats, s)
+	}
+	p.mutex.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Key < stats[j].Key })
+	return stats
+}
+
+// Evict 关闭键对应的连接，正在使用该连接的会话和通道随之结束
+func (p *ConnectionPool) Evict(key string) error {
+	p.mutex.RLock()
+	entry, exists := p.connections[key]
+	p.mutex.RUnlock()
+	if !exists {
+		return fmt.Errorf("no pooled connection with key '%s'", key)
+	}
+	debugf("closing pooled connection %s on request", key)
+	p.closeEntry(entry, errClosedByUser)
+	return nil
+}
+
+// EvictAll 关闭连接池中的所有连接，返回关闭的连接数。与 Close 不同，之后仍可建立新连接
+func (p *ConnectionPool) EvictAll() int {
+	p.mutex.RLock()
+	entries := make([]*pooledClient, 0, len(p.connections))
+	for _, entry := range p.connections {
+		entries = append(entries, entry)
+	}
+	p.mutex.RUnlock()
+
+	for _, entry := range entries {
+		p.closeEntry(entry, errClosedByUser)
+	}
+	return len(entries)
+}
+
+// recordRTT 记录一次成功心跳的往返时间
+func (p *ConnectionPool) recordRTT(entry *pooledClient, rtt time.Duration) {
+	p.mutex.Lock()
+	entry.rtt = rtt
+	p.mutex.Unlock()
+}
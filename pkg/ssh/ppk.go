@@ -0,0 +1,335 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/ssh"
+)
+
+// PuTTY 私钥文件头前缀
+const ppkHeaderPrefix = "PuTTY-User-Key-File-"
+
+// ppkFile 保存 .ppk 文件中的字段
+type ppkFile struct {
+	version    int
+	algorithm  string
+	encryption string
+	comment    string
+	public     []byte
+	private    []byte
+	mac        []byte
+
+	// v3 的密钥派生参数
+	kdf         string
+	memory      uint32
+	passes      uint32
+	parallelism uint8
+	salt        []byte
+}
+
+// IsPPK 判断数据是否为 PuTTY .ppk 私钥
+func IsPPK(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(data, "\ufeff \t\r\n"), []byte(ppkHeaderPrefix))
+}
+
+// ParsePPK 解析 PuTTY .ppk（v2/v3）私钥，返回原始私钥和注释。
+// 私钥已加密而未提供密码时返回 *ssh.PassphraseMissingError，密码错误时返回 x509.IncorrectPasswordError
+func ParsePPK(data, passphrase []byte) (crypto.PrivateKey, string, error) {
+	f, err := readPPK(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pub, err := ssh.ParsePublicKey(f.public)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid ppk public key: %w", err)
+	}
+	if pub.Type() != f.algorithm {
+		return nil, "", fmt.Errorf("invalid ppk file: header algorithm %s does not match public key %s", f.algorithm, pub.Type())
+	}
+
+	var cipherKey, iv, macKey []byte
+	switch f.encryption {
+	case "none":
+		if f.version == 2 {
+			macKey = ppkV2MACKey(nil)
+		}
+	case "aes256-cbc":
+		if len(passphrase) == 0 {
+			return nil, "", &ssh.PassphraseMissingError{PublicKey: pub}
+		}
+		if len(f.private)%aes.BlockSize != 0 {
+			return nil, "", errors.New("invalid ppk file: encrypted private key is not a multiple of the block size")
+		}
+		if f.version == 2 {
+			cipherKey, iv, macKey = ppkV2Keys(passphrase)
+		} else {
+			if cipherKey, iv, macKey, err = f.deriveV3Keys(passphrase); err != nil {
+				return nil, "", err
+			}
+		}
+	default:
+		return nil, "", fmt.Errorf("unsupported ppk encryption '%s'", f.encryption)
+	}
+
+	private := f.private
+	if cipherKey != nil {
+		block, err := aes.NewCipher(cipherKey)
+		if err != nil {
+			return nil, "", err
+		}
+		private = make([]byte, len(f.private))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(private, f.private)
+	}
+
+	// MAC 覆盖算法、加密方式、注释、公钥和解密后的私钥，加密私钥的 MAC 不匹配即密码错误
+	var newHash func() hash.Hash = sha256.New
+	if f.version == 2 {
+		newHash = sha1.New
+	}
+	mac := hmac.New(newHash, macKey)
+	for _, field := range [][]byte{[]byte(f.algorithm), []byte(f.encryption), []byte(f.comment), f.public, private} {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(field)))
+		mac.Write(length[:])
+		mac.Write(field)
+	}
+	if !hmac.Equal(mac.Sum(nil), f.mac) {
+		if cipherKey != nil {
+			return nil, "", x509.IncorrectPasswordError
+		}
+		return nil, "", errors.New("invalid ppk file: MAC verification failed")
+	}
+
+	key, err := ppkPrivateKey(pub, private)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, f.comment, nil
+}
+
+// readPPK 读取 .ppk 文件的头部字段和 base64 数据块
+func readPPK(data []byte) (*ppkFile, error) {
+	f := &ppkFile{}
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimLeft(data, "\ufeff \t\r\n")))
+
+	next := func() (string, string, error) {
+		if !scanner.Scan() {
+			return "", "", errors.New("invalid ppk file: unexpected end of file")
+		}
+		name, value, ok := strings.Cut(strings.TrimRight(scanner.Text(), "\r"), ": ")
+		if !ok {
+			return "", "", fmt.Errorf("invalid ppk file: malformed line %q", scanner.Text())
+		}
+		return name, value, nil
+	}
+	expect := func(want string) (string, error) {
+		name, value, err := next()
+		if err != nil {
+			return "", err
+		}
+		if name != want {
+			return "", fmt.Errorf("invalid ppk file: expected %s, found %s", want, name)
+		}
+		return value, nil
+	}
+	readLines := func(want string) ([]byte, error) {
+		value, err := expect(want)
+		if err != nil {
+			return nil, err
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("invalid ppk file: bad %s value %q", want, value)
+		}
+		var encoded strings.Builder
+		for i := 0; i < count; i++ {
+			if !scanner.Scan() {
+				return nil, errors.New("invalid ppk file: unexpected end of file")
+			}
+			encoded.WriteString(strings.TrimSpace(scanner.Text()))
+		}
+		return base64.StdEncoding.DecodeString(encoded.String())
+	}
+
+	name, value, err := next()
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case ppkHeaderPrefix + "2":
+		f.version = 2
+	case ppkHeaderPrefix + "3":
+		f.version = 3
+	default:
+		return nil, fmt.Errorf("unsupported ppk format '%s': only versions 2 and 3 are supported", name)
+	}
+	f.algorithm = value
+
+	if f.encryption, err = expect("Encryption"); err != nil {
+		return nil, err
+	}
+	if f.comment, err = expect("Comment"); err != nil {
+		return nil, err
+	}
+	if f.public, err = readLines("Public-Lines"); err != nil {
+		return nil, err
+	}
+
+	if f.version == 3 && f.encryption != "none" {
+		if f.kdf, err = expect("Key-Derivation"); err != nil {
+			return nil, err
+		}
+		for _, field := range []struct {
+			name string
+			bits int
+			dest func(uint64)
+		}{
+			{"Argon2-Memory", 32, func(v uint64) { f.memory = uint32(v) }},
+			{"Argon2-Passes", 32, func(v uint64) { f.passes = uint32(v) }},
+			{"Argon2-Parallelism", 8, func(v uint64) { f.parallelism = uint8(v) }},
+		} {
+			value, err := expect(field.name)
+			if err != nil {
+				return nil, err
+			}
+			v, err := strconv.ParseUint(value, 10, field.bits)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ppk file: bad %s value %q", field.name, value)
+			}
+			field.dest(v)
+		}
+		value, err := expect("Argon2-Salt")
+		if err != nil {
+			return nil, err
+		}
+		if f.salt, err = hex.DecodeString(value); err != nil {
+			return nil, fmt.Errorf("invalid ppk file: bad Argon2-Salt: %w", err)
+		}
+	}
+
+	if f.private, err = readLines("Private-Lines"); err != nil {
+		return nil, err
+	}
+	value, err = expect("Private-MAC")
+	if err != nil {
+		return nil, err
+	}
+	if f.mac, err = hex.DecodeString(value); err != nil {
+		return nil, fmt.Errorf("invalid ppk file: bad Private-MAC: %w", err)
+	}
+
+	return f, nil
+}
+
+// deriveV3Keys 使用 Argon2 从密码派生 v3 的加密密钥、IV 和 MAC 密钥
+func (f *ppkFile) deriveV3Keys(passphrase []byte) (cipherKey, iv, macKey []byte, err error) {
+	const length = 32 + aes.BlockSize + 32
+
+	var out []byte
+	switch f.kdf {
+	case "Argon2id":
+		out = argon2.IDKey(passphrase, f.salt, f.passes, f.memory, f.parallelism, length)
+	case "Argon2i":
+		out = argon2.Key(passphrase, f.salt, f.passes, f.memory, f.parallelism, length)
+	default:
+		return nil, nil, nil, fmt.Errorf("unsupported ppk key derivation '%s': only Argon2id and Argon2i are supported", f.kdf)
+	}
+	return out[:32], out[32 : 32+aes.BlockSize], out[32+aes.BlockSize:], nil
+}
+
+// ppkV2Keys 按 v2 的规则（SHA-1）从密码派生加密密钥、IV 和 MAC 密钥
+func ppkV2Keys(passphrase []byte) (cipherKey, iv, macKey []byte) {
+	var key []byte
+	for seq := uint32(0); seq < 2; seq++ {
+		h := sha1.New()
+		binary.Write(h, binary.BigEndian, seq)
+		h.Write(passphrase)
+		key = h.Sum(key)
+	}
+	return key[:32], make([]byte, aes.BlockSize), ppkV2MACKey(passphrase)
+}
+
+// ppkV2MACKey 返回 v2 的 MAC 密钥，未加密的私钥使用空密码
+func ppkV2MACKey(passphrase []byte) []byte {
+	h := sha1.New()
+	h.Write([]byte("putty-private-key-file-mac-key"))
+	h.Write(passphrase)
+	return h.Sum(nil)
+}
+
+// ppkPrivateKey 根据公钥类型解析私钥数据
+func ppkPrivateKey(pub ssh.PublicKey, private []byte) (crypto.PrivateKey, error) {
+	cryptoPub, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported ppk key type %s", pub.Type())
+	}
+
+	switch pubKey := cryptoPub.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		var priv struct {
+			D    *big.Int
+			P    *big.Int
+			Q    *big.Int
+			Iqmp *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(private, &priv); err != nil {
+			return nil, fmt.Errorf("invalid ppk rsa private key: %w", err)
+		}
+		key := &rsa.PrivateKey{PublicKey: *pubKey, D: priv.D, Primes: []*big.Int{priv.P, priv.Q}}
+		if err := key.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid ppk rsa private key: %w", err)
+		}
+		key.Precompute()
+		return key, nil
+	case *ecdsa.PublicKey:
+		var priv struct {
+			D    *big.Int
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(private, &priv); err != nil {
+			return nil, fmt.Errorf("invalid ppk ecdsa private key: %w", err)
+		}
+		return &ecdsa.PrivateKey{PublicKey: *pubKey, D: priv.D}, nil
+	case ed25519.PublicKey:
+		var priv struct {
+			Seed []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(private, &priv); err != nil {
+			return nil, fmt.Errorf("invalid ppk ed25519 private key: %w", err)
+		}
+		if len(priv.Seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid ppk ed25519 private key length %d", len(priv.Seed))
+		}
+		key := ed25519.NewKeyFromSeed(priv.Seed)
+		if !bytes.Equal(key.Public().(ed25519.PublicKey), pubKey) {
+			return nil, errors.New("invalid ppk ed25519 private key: does not match the public key")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported ppk key type %s", pub.Type())
+	}
+}
@@ -0,0 +1,175 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/config"
+	"golang.org/x/net/proxy"
+)
+
+// 各代理类型未指定端口时使用的默认端口
+var defaultProxyPorts = map[string]string{
+	"http":    "80",
+	"https":   "443",
+	"socks5":  "1080",
+	"socks5h": "1080",
+	"socks4":  "1080",
+	"socks4a": "1080",
+}
+
+// resolveProxy 返回连接实际使用的代理地址，返回 nil 表示直接连接
+func resolveProxy(conn *config.Connection) (*url.URL, error) {
+	raw := conn.Proxy
+	switch raw {
+	case "", config.ProxyNone:
+		return nil, nil
+	case config.ProxyEnv:
+		raw = proxyFromEnvironment(conn)
+		if raw == "" {
+			return nil, nil
+		}
+		// 与 curl 一致，环境变量中未写协议的代理视为 HTTP 代理
+		if !strings.Contains(raw, "://") {
+			raw = "http://" + raw
+		}
+	}
+
+	// 解析代理URL
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse proxy URL: %w", err)
+	}
+	if _, ok := defaultProxyPorts[proxyURL.Scheme]; !ok {
+		return nil, fmt.Errorf("unsupported proxy type: %s", proxyURL.Scheme)
+	}
+	return proxyURL, nil
+}
+
+// proxyFromEnvironment 从 ALL_PROXY 读取代理，主机匹配 NO_PROXY 时返回空字符串
+func proxyFromEnvironment(conn *config.Connection) string {
+	proxyEnv := getenvAny("ALL_PROXY", "all_proxy")
+	if proxyEnv == "" {
+		debugf("no ALL_PROXY set, connecting to %s directly", conn.Host)
+		return ""
+	}
+	if config.MatchNoProxy(strings.Split(getenvAny("NO_PROXY", "no_proxy"), ","), conn.Host, conn.Port) {
+		debugf("%s matches NO_PROXY, connecting directly", conn.Host)
+		return ""
+	}
+	return proxyEnv
+}
+
+// getenvAny 返回第一个非空的环境变量值
+func getenvAny(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// dialProxy 通过代理建立到目标地址的连接。本地解析目标主机名时（socks5、socks4）遵循连接的地址族
+func dialProxy(conn *config.Connection, proxyURL *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	proxyAddr, err := proxyHostPort(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	debugf("connecting to %s via %s proxy %s", addr, proxyURL.Scheme, proxyAddr)
+
+	switch proxyURL.Scheme {
+	case "http", "https":
+		return dialHTTPConnect(proxyURL, proxyAddr, addr, conn.ProxyCAFile, timeout)
+
+	case "socks5", "socks5h":
+		// socks5 在本地解析目标主机名，socks5h 由代理服务器解析
+		if proxyURL.Scheme == "socks5" {
+			if addr, err = resolveTargetAddr(addr, ipNetwork(conn), timeout); err != nil {
+				return nil, err
+			}
+		}
+
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{
+				User:     proxyURL.User.Username(),
+				Password: password,
+			}
+		}
+
+		dialer, err := proxy.SOCKS5("tcp", proxyAddr, auth, &net.Dialer{Timeout: timeout})
+		if err != nil {
+			return nil, fmt.Errorf("unable to create SOCKS5 proxy dialer: %w", err)
+		}
+
+		netConn, err := dialer.Dial("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect through SOCKS5 proxy: %w", err)
+		}
+		return netConn, nil
+
+	case "socks4", "socks4a":
+		userID := ""
+		if proxyURL.User != nil {
+			userID = proxyURL.User.Username()
+		}
+		if conn.AddressFamily == AddressFamilyInet6 {
+			return nil, fmt.Errorf("SOCKS4 proxies do not support address_family inet6")
+		}
+		return dialSOCKS4(proxyAddr, addr, userID, proxyURL.Scheme == "socks4a", timeout)
+
+	default:
+		return nil, fmt.Errorf("unsupported proxy type: %s", proxyURL.Scheme)
+	}
+}
+
+// resolveTargetAddr 在本地解析目标地址中的主机名，返回 IP:port，network 为 "ip"、"ip4" 或 "ip6"
+func resolveTargetAddr(addr, network string, timeout time.Duration) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Zone() != "" {
+			return "", fmt.Errorf("link-local address %s cannot be reached through a proxy", host)
+		}
+		return addr, nil
+	}
+
+	ip, err := lookupIP(host, network, timeout)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// lookupIP 在本地解析主机名并返回第一个地址，network 为 "ip"、"ip4" 或 "ip6"
+func lookupIP(host, network string, timeout time.Duration) (net.IP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve %s: %w", host, err)
+	}
+	return ips[0], nil
+}
+
+// proxyHostPort 返回代理服务器的 host:port，未指定端口时使用该类型的默认端口
+func proxyHostPort(proxyURL *url.URL) (string, error) {
+	if proxyURL.Hostname() == "" {
+		return "", fmt.Errorf("invalid proxy URL '%s': missing host", proxyURL.Redacted())
+	}
+	port := proxyURL.Port()
+	if port == "" {
+		port = defaultProxyPorts[proxyURL.Scheme]
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), port), nil
+}
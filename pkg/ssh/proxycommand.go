@@ -0,0 +1,234 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/justseemore/sshm/pkg/config"
+)
+
+// 错误信息中保留的代理命令标准错误输出的最大长度
+const proxyCommandStderrLimit = 4096
+
+// 代理命令退出后等待其输出管道关闭的时间，避免后台子进程持有管道导致阻塞
+const proxyCommandWaitDelay = time.Second
+
+// expandProxyCommand 替换代理命令中的 %h（主机）、%p（端口）、%r（用户名）和 %%
+func expandProxyCommand(command, host string, port int, user string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(command); i++ {
+		if command[i] != '%' {
+			b.WriteByte(command[i])
+			continue
+		}
+		if i+1 >= len(command) {
+			return "", fmt.Errorf("invalid proxy command '%s': trailing '%%'", command)
+		}
+		i++
+		switch command[i] {
+		case 'h':
+			b.WriteString(host)
+		case 'p':
+			b.WriteString(strconv.Itoa(port))
+		case 'r':
+			b.WriteString(user)
+		case '%':
+			b.WriteByte('%')
+		default:
+			return "", fmt.Errorf("invalid proxy command '%s': unknown token '%%%c'", command, command[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// ValidateProxyCommand 检查代理命令中的替换符是否有效
+func ValidateProxyCommand(command string) error {
+	_, err := expandProxyCommand(command, "", 0, "")
+	return err
+}
+
+// dialProxyCommand 启动代理命令，并将其标准输入输出作为到目标地址 addr 的连接
+func dialProxyCommand(conn *config.Connection, addr, user string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %s", addr)
+	}
+	command, err := expandProxyCommand(conn.ProxyCommand, host, port, user)
+	if err != nil {
+		return nil, err
+	}
+	debugf("running proxy command: %s", command)
+
+	// 使用自建管道而不是 StdoutPipe，这样进程退出时不会关闭仍在读取的一端
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create proxy command pipe: %w", err)
+	}
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		stdinReader.Close()
+		stdinWriter.Close()
+		return nil, fmt.Errorf("unable to create proxy command pipe: %w", err)
+	}
+
+	c := &commandConn{
+		command: command,
+		addr:    commandAddr(addr),
+		stdin:   stdinWriter,
+		stdout:  stdoutReader,
+		done:    make(chan struct{}),
+	}
+	c.cmd = shellCommand(context.Background(), command)
+	c.cmd.Stdin = stdinReader
+	c.cmd.Stdout = stdoutWriter
+	c.cmd.Stderr = &c.stderr
+	if Verbose {
+		c.cmd.Stderr = io.MultiWriter(&c.stderr, os.Stderr)
+	}
+	c.cmd.WaitDelay = proxyCommandWaitDelay
+
+	err = c.cmd.Start()
+	// 子进程已持有自己的一端，父进程关闭副本以便正确感知 EOF
+	stdinReader.Close()
+	stdoutWriter.Close()
+	if err != nil {
+		stdinWriter.Close()
+		stdoutReader.Close()
+		return nil, fmt.Errorf("unable to start proxy command '%s': %w", command, err)
+	}
+
+	go func() {
+		c.waitErr = c.cmd.Wait()
+		close(c.done)
+	}()
+	return c, nil
+}
+
+// commandConn 将代理命令的标准输入输出包装为 net.Conn
+type commandConn struct {
+	command string
+	addr    commandAddr
+	cmd     *exec.Cmd
+	stdin   *os.File
+	stdout  *os.File
+	stderr  stderrTail
+
+	done      chan struct{}
+	waitErr   error
+	closeOnce sync.Once
+}
+
+func (c *commandConn) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if err == io.EOF {
+		// 命令关闭了输出，通常意味着它已经退出，此时用退出原因代替 EOF
+		select {
+		case <-c.done:
+		case <-time.After(proxyCommandWaitDelay):
+			return n, err
+		}
+		if exitErr := c.exitError(); exitErr != nil {
+			return n, exitErr
+		}
+	}
+	return n, err
+}
+
+func (c *commandConn) Write(p []byte) (int, error) {
+	n, err := c.stdin.Write(p)
+	if err != nil {
+		select {
+		case <-c.done:
+			if exitErr := c.exitError(); exitErr != nil {
+				return n, exitErr
+			}
+		default:
+		}
+	}
+	return n, err
+}
+
+// exitError 在命令失败或输出了错误信息时返回包含标准错误的错误
+func (c *commandConn) exitError() error {
+	stderr := c.stderr.String()
+	if c.waitErr == nil && stderr == "" {
+		return nil
+	}
+	err := c.waitErr
+	if err == nil {
+		err = errors.New("closed the connection")
+	}
+	if stderr == "" {
+		return fmt.Errorf("proxy command '%s' failed: %w", c.command, err)
+	}
+	return fmt.Errorf("proxy command '%s' failed: %w: %s", c.command, err, stderr)
+}
+
+// Close 关闭管道并结束代理命令
+func (c *commandConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.stdin.Close()
+		c.stdout.Close()
+		select {
+		case <-c.done:
+		default:
+			c.cmd.Process.Kill()
+			<-c.done
+		}
+	})
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr  { return commandAddr("127.0.0.1:0") }
+func (c *commandConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *commandConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return c.stdout.SetReadDeadline(t) }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return c.stdin.SetWriteDeadline(t) }
+
+// commandAddr 代理命令连接的地址，使用目标 host:port 以便 known_hosts 按主机名校验
+type commandAddr string
+
+func (a commandAddr) Network() string { return "proxy-command" }
+func (a commandAddr) String() string  { return string(a) }
+
+// stderrTail 并发安全地保留代理命令标准错误输出的末尾部分
+type stderrTail struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > proxyCommandStderrLimit {
+		t.buf = t.buf[len(t.buf)-proxyCommandStderrLimit:]
+	}
+	return len(p), nil
+}
+
+func (t *stderrTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.TrimSpace(string(t.buf))
+}
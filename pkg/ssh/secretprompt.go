@@ -0,0 +1,99 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/justseemore/sshm/pkg/config"
+)
+
+// 本进程内保留的交互式输入密码
+var rememberedSecrets = struct {
+	sync.Mutex
+	values map[string]string
+}{values: make(map[string]string)}
+
+// secretRequest 描述一次交互式密码输入
+type secretRequest struct {
+	cacheKey string             // 进程内缓存的键
+	message  string             // 提示信息
+	remember string             // 保留方式："process"、"vault" 或空
+	save     func(string) error // 写入保险库的函数，为 nil 时只能在进程内保留
+	hint     string             // 没有终端时的错误提示
+}
+
+// providedSecret 一次握手中为某个缓存键提供的密码
+type providedSecret struct {
+	value string
+	// 已登记在认证成功后保留
+	remember bool
+}
+
+// rememberInProcess 将 vault 保留方式降级为进程内保留
+func rememberInProcess(mode string) string {
+	if mode == config.RememberVault {
+		return config.RememberProcess
+	}
+	return mode
+}
+
+// promptSecret 在终端中输入密码。选择保留的密码在认证成功后才会缓存或写入保险库，
+// 且只保存最后一次（即认证成功的）输入，避免保存输错的密码
+func (a *authConfig) promptSecret(req secretRequest) (string, error) {
+	_, retry := a.secrets[req.cacheKey]
+	rememberedSecrets.Lock()
+	secret, ok := rememberedSecrets.values[req.cacheKey]
+	if retry {
+		// 同一次握手中再次请求说明上一次的密码错误，缓存的密码已失效
+		delete(rememberedSecrets.values, req.cacheKey)
+		ok = false
+	}
+	rememberedSecrets.Unlock()
+	if ok {
+		a.useSecret(req, secret, false)
+		return secret, nil
+	}
+
+	if !a.prompter.IsInteractive() {
+		return "", errors.New(req.hint)
+	}
+
+	secret, err := a.prompter.Password(req.message)
+	if err != nil {
+		return "", err
+	}
+	a.useSecret(req, secret, req.remember != "")
+	return secret, nil
+}
+
+// useSecret 记录本次握手提供的密码，remember 为 true 时在认证成功后保留最后一次提供的密码
+func (a *authConfig) useSecret(req secretRequest, secret string, remember bool) {
+	if a.secrets == nil {
+		a.secrets = make(map[string]*providedSecret)
+	}
+	provided := a.secrets[req.cacheKey]
+	if provided == nil {
+		provided = &providedSecret{}
+		a.secrets[req.cacheKey] = provided
+	}
+	provided.value = secret
+	if !remember || provided.remember {
+		return
+	}
+	provided.remember = true
+
+	a.onSuccess = append(a.onSuccess, func() {
+		secret := provided.value
+		rememberedSecrets.Lock()
+		rememberedSecrets.values[req.cacheKey] = secret
+		rememberedSecrets.Unlock()
+
+		if req.remember == config.RememberVault && req.save != nil {
+			if err := req.save(secret); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: unable to store the secret in the vault: %v\n", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package ssh
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// SOCKS4 协议常量
+const (
+	socks4Version = 4
+	socks4Connect = 1
+	socks4Granted = 90
+)
+
+// socks4Replies SOCKS4 拒绝请求时的状态码说明
+var socks4Replies = map[byte]string{
+	91: "request rejected or failed",
+	92: "proxy cannot reach the client's identd",
+	93: "identd reported a different user ID",
+}
+
+// dialSOCKS4 通过 SOCKS4 代理连接目标地址。SOCKS4 只支持 IPv4，主机名在本地解析；
+// remoteDNS 为 true 时使用 SOCKS4a 扩展，由代理服务器解析主机名
+func dialSOCKS4(proxyAddr, addr, userID string, remoteDNS bool, timeout time.Duration) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %s", addr)
+	}
+
+	// 构造请求：VN CD DSTPORT DSTIP USERID NUL [HOSTNAME NUL]
+	req := []byte{socks4Version, socks4Connect}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	ip, ipErr := netip.ParseAddr(host)
+	switch {
+	case ipErr == nil && ip.Unmap().Is4():
+		ip4 := ip.Unmap().As4()
+		req = append(req, ip4[:]...)
+		req = append(req, userID...)
+		req = append(req, 0)
+	case ipErr == nil:
+		return nil, fmt.Errorf("SOCKS4 proxies do not support IPv6 address %s", host)
+	case remoteDNS:
+		// 0.0.0.x（x 非 0）表示目标地址为随后的主机名
+		req = append(req, 0, 0, 0, 1)
+		req = append(req, userID...)
+		req = append(req, 0)
+		req = append(req, host...)
+		req = append(req, 0)
+	default:
+		// SOCKS4 只能使用 IPv4 地址
+		resolved, err := lookupIP(host, "ip4", timeout)
+		if err != nil {
+			return nil, fmt.Errorf("%w (use socks4a to let the proxy resolve it)", err)
+		}
+		req = append(req, resolved.To4()...)
+		req = append(req, userID...)
+		req = append(req, 0)
+	}
+
+	netConn, err := net.DialTimeout("tcp", proxyAddr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to SOCKS4 proxy %s: %w", proxyAddr, err)
+	}
+	netConn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := netConn.Write(req); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("unable to send SOCKS4 request: %w", err)
+	}
+	var reply [8]byte
+	if _, err := io.ReadFull(netConn, reply[:]); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("unable to read SOCKS4 reply: %w", err)
+	}
+	if reply[1] != socks4Granted {
+		netConn.Close()
+		reason, ok := socks4Replies[reply[1]]
+		if !ok {
+			reason = fmt.Sprintf("unknown reply code %d", reply[1])
+		}
+		return nil, fmt.Errorf("SOCKS4 proxy refused connection to %s: %s", addr, reason)
+	}
+
+	netConn.SetDeadline(time.Time{})
+	return netConn, nil
+}